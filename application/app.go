@@ -1,12 +1,20 @@
 package application
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"image"
+	"io"
+	"io/fs"
 	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/glekoz/online-shop_image/internal/imageproc"
 	"github.com/glekoz/online-shop_image/internal/models"
 	"github.com/google/uuid"
 )
@@ -16,11 +24,18 @@ const (
 	ImageStatusFree = "free"
 )
 
+// сколько времени дается на снятие busy статусов при остановке, даже если общий дедлайн уже истек
+const releaseTimeout = 5 * time.Second
+
 type StorageAPI interface {
-	Save(ctx context.Context, service, entityID, imageID string, img image.Image) (string, error)
-	Delete(path string) error
+	Save(ctx context.Context, service, entityID, imageID string, img image.Image, opts models.EncodeOptions) (models.StoredFile, error)
+	// SaveRaw сохраняет байты как есть, без перекодирования. format - один из imageproc.Formats,
+	// size - длина r, -1 - неизвестна
+	SaveRaw(ctx context.Context, service, entityID, imageID, format string, r io.Reader, size int64) (models.StoredFile, error)
+	// Delete отменяет один Save: в режиме cas файл удаляется только вместе с последней ссылкой на него
+	Delete(service, entityID, path string) error
 	DeleteAll(service, entityID string) error
-	GetRawImage(imagePath string) (image.Image, error)
+	GetRawImage(ctx context.Context, imagePath string) (image.Image, error)
 	// UpdateMainPhoto(dir, id string, img image.Image) error - ЭТО НАДО СДЕЛАТЬ
 	//ItemsInDir(dir string) (int, error)
 }
@@ -35,6 +50,9 @@ type DBAPI interface {
 	SetStatus(ctx context.Context, service, entityID, status string) error
 	GetCoverImage(ctx context.Context, service, entityID string) (models.EntityImage, error)
 	GetImageList(ctx context.Context, service, entityID string) ([]models.EntityImage, error)
+	GetImageVariants(ctx context.Context, service, entityID, imagePath string) ([]models.ImageVariant, error)
+	GetImageHashes(ctx context.Context, service, entityID string) ([]models.ImageHash, error)
+	FindEntitiesByColor(ctx context.Context, service, name string, minShare float64, after string, limit int) ([]string, error)
 }
 
 type AMTAPI interface {
@@ -46,6 +64,9 @@ type App struct {
 	Storage  StorageAPI
 	ImageAMT AMTAPI
 	SC       *SyncController
+	Services map[string]Service // настройки обработки по имени сервиса
+	jobs     sync.WaitGroup     // фоновые горутины InitialSave и ProcessedSave
+	closing  atomic.Bool
 	// Logger говорят, надо саму ошибку в месте появления логировать
 	// Jaeger tracer
 }
@@ -56,9 +77,9 @@ type App struct {
 
 // общение с различными сервисами уже в main функции можно настроить с помощью одного соединения amt.Dial()
 // но настройки у всех разные, поэтому надо 3 экземпляра и передать
-func NewApp(db DBAPI, s StorageAPI, image AMTAPI) *App {
+func NewApp(db DBAPI, s StorageAPI, image AMTAPI, services map[string]Service) *App {
 	syncController := NewSyncController(db, s)
-	return &App{DB: db, Storage: s, ImageAMT: image, SC: syncController}
+	return &App{DB: db, Storage: s, ImageAMT: image, SC: syncController, Services: services}
 }
 
 func (a *App) CreateEntity(ctx context.Context, service, entityID string, maxCount int) error {
@@ -83,6 +104,8 @@ func (a *App) DeleteEntity(ctx context.Context, service, entityID string) error
 	if err != nil {
 		return models.NewError(loc, service+" "+entityID, err)
 	}
+	a.SC.UnmarkBusy(service, entityID)
+	a.SC.ForgetUploads(service, entityID)
 	return nil
 }
 
@@ -91,82 +114,128 @@ func (a *App) DeleteEntity(ctx context.Context, service, entityID string) error
 // которое передается в дальнейших запросах к этому сервису
 // создается в сервисе ещё и таблица со списиком изображений,
 // и таблица с количеством изображений, статусом, есть ли сейчас изображения в обработке, и общем количестве разрешенных иозбражений
-func (a *App) InitialSave(ctx context.Context, service, entityID string, isCover bool, img image.Image) (string, error) { // может, сразу изображение давать? 100% зря логику вызывать не буду
+// Похожее на уже загруженное изображение по политике сервиса либо отклоняется (*models.DuplicateError),
+// либо сохраняется с UploadResult.DuplicateOf. Так же с низким качеством: *models.LowQualityError
+// или UploadResult.QualityIssues. data - байты загрузки, они сохраняются во временный файл без
+// перекодирования, но без метаданных, decoded - результат их Decode, focus - точка интереса от клиента, nil - нет
+func (a *App) InitialSave(ctx context.Context, service, entityID string, isCover bool, data []byte, decoded imageproc.Decoded, focus *models.FocalPoint) (models.UploadResult, error) { // может, сразу изображение давать? 100% зря логику вызывать не буду
 	loc := "App.InitialSave"
 
 	type Result struct {
-		imageID string
-		err     error
+		upload models.UploadResult
+		err    error
+	}
+
+	if a.closing.Load() {
+		return models.UploadResult{}, models.NewError(loc, service+" "+entityID, models.ErrShuttingDown)
 	}
 
 	resChan := make(chan Result, 1)
 
+	a.jobs.Add(1)
 	go func(ch chan<- Result) {
+		defer a.jobs.Done()
 		defer close(ch)
 
 		if ctx.Err() != nil {
-			ch <- Result{"", models.NewError(loc, service+" "+entityID, ctx.Err())}
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID, ctx.Err())}
 			return
 		}
 
 		imageID := uuid.New().String()
+		// качество проверяется первым: отклоненное изображение не должно попасть в сравнение дубликатов стрима
+		issues, err := a.checkQuality(ctx, service, imageID, decoded.Image)
+		if err != nil {
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID+" "+imageID, err)}
+			return
+		}
+		duplicateOf, err := a.checkDuplicate(ctx, service, entityID, imageID, decoded.Image)
+		if err != nil {
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID+" "+imageID, err)}
+			return
+		}
+		var published bool
+		defer func() {
+			if !published {
+				a.SC.ForgetUpload(service, entityID, imageID) // иначе с ним сравнивались бы следующие изображения стрима
+			}
+		}()
+
 		tmpEntityID := filepath.Join(entityID, "tmp")
-		tmpImgPath, err := a.Storage.Save(ctx, service, tmpEntityID, imageID, img)
+		// исходные байты без перекодирования, но и без EXIF, XMP и прочих метаданных: ProcessedSave
+		// декодирует их заново, приводит к sRGB по профилю ICC и разворачивает по TmpOrientation
+		var tmpFile models.StoredFile
+		tmpOrientation := decoded.Orientation // тег уходит из файла вместе с EXIF
+		clean, err := imageproc.StripMetadata(data)
+		if err == nil {
+			tmpFile, err = a.Storage.SaveRaw(ctx, service, tmpEntityID, imageID, decoded.Format, bytes.NewReader(clean), int64(len(clean)))
+		} else {
+			tmpOrientation = imageproc.OrientationUnknown
+			// TIFF и файлы, которые не удалось разобрать, перекодируются - в пикселях уже нет ни метаданных, ни поворота
+			tmpFile, err = a.Storage.Save(ctx, service, tmpEntityID, imageID, decoded.Image, models.EncodeOptions{})
+		}
 		if err != nil {
-			ch <- Result{"", models.NewError(loc, service+" "+entityID+" "+imageID, err)} // ок для логирования, но для передачи ошибок выше надо что-то другое придумать
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID+" "+imageID, err)} // ок для логирования, но для передачи ошибок выше надо что-то другое придумать
 			return
 		}
 
+		tmpImgPath := tmpFile.Key
 		defer func() {
 			if ctx.Err() != nil || err != nil {
-				a.Storage.Delete(tmpImgPath) // удалить временное изображение, если не удалось опубликовать сообщение
+				a.Storage.Delete(service, tmpEntityID, tmpImgPath) // удалить временное изображение, если не удалось опубликовать сообщение
 				//log
 			}
 		}()
 
 		if ctx.Err() != nil {
-			ch <- Result{"", models.NewError(loc, service+" "+entityID, ctx.Err())}
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID, ctx.Err())}
 			return
 		}
 
 		amtMsg := models.ProcessImageMessage{
-			Service:      service,
-			EntityID:     entityID,
-			ImageID:      imageID,
-			IsCover:      isCover,
-			TmpImagePath: tmpImgPath,
+			Service:         service,
+			EntityID:        entityID,
+			ImageID:         imageID,
+			IsCover:         isCover,
+			TmpImagePath:    tmpImgPath,
+			Orientation:     decoded.Orientation,
+			TmpOrientation:  tmpOrientation,
+			ColorConversion: decoded.ColorConversion,
+			DuplicateOf:     duplicateOf,
+			Focus:           focus,
 		}
 		msg, err := json.Marshal(amtMsg)
 		if err != nil {
-			ch <- Result{"", models.NewError(loc, service+" "+entityID+" "+imageID, err)}
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID+" "+imageID, err)}
 			return
 		}
 
 		err = a.ImageAMT.Publish(ctx, msg)
 		if err != nil {
-			ch <- Result{"", models.NewError(loc, service+" "+entityID+" "+imageID, err)}
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID+" "+imageID, err)}
 			return
 		}
 
 		if ctx.Err() != nil {
-			ch <- Result{"", models.NewError(loc, service+" "+entityID, ctx.Err())}
+			ch <- Result{models.UploadResult{}, models.NewError(loc, service+" "+entityID, ctx.Err())}
 			return
 		}
+		published = true
 		serviceDirName := filepath.Join(service, entityID)
 		a.SC.ReqCountIncrement(serviceDirName)
-		ch <- Result{imageID, nil}
+		ch <- Result{models.UploadResult{ImageID: imageID, DuplicateOf: duplicateOf, QualityIssues: issues}, nil}
 	}(resChan)
 
 	select {
 	case <-ctx.Done():
 		// залогировать
-		return "", models.NewError(loc, service+" "+entityID, ctx.Err())
+		return models.UploadResult{}, models.NewError(loc, service+" "+entityID, ctx.Err())
 	case res := <-resChan:
 		if res.err != nil {
 			// залогировать
-			return "", res.err // хотя само по себе ерр мб нил, но логировать тогда что?
+			return models.UploadResult{}, res.err // хотя само по себе ерр мб нил, но логировать тогда что?
 		}
-		return res.imageID, nil
+		return res.upload, nil
 	}
 	// И ТУТ МНЕ НЕ НУЖНА УНИКАЛЬНАЯ БЛОКИРОВКА НА ДИРЕКТОРИЮ - У МЕНЯ ТОЛЬКО 2 ОБЩИЕ МАПЫ, КОТОРЫЕ СЧИТАЮТ, НЕ ПРЕВЫШЕН ЛИ ЛИМИТ
 	// И ПОТОМ ДЕЛАЙ ЧТО ХОЧЕШЬ, ТОЛЬКО ПУТЬ К ВРЕМЕННОМУ НЕОБРАБОТАННОМУ ИЗОБРАЖЕНИЮ ПЕРЕДАЙ
@@ -191,16 +260,27 @@ func (a *App) InitialSave(ctx context.Context, service, entityID string, isCover
 
 // а этот из AMT - уже там настраивается параллельность
 // значит, нужна система ошибок и контексты
-func (a *App) ProcessedSave(ctx context.Context, service, entityID, imageID, tmpImagePath string, isCover bool) error { // img = full path to temp raw image file
-
+func (a *App) ProcessedSave(ctx context.Context, msg models.ProcessImageMessage) error {
 	loc := "App.ProcessedSave"
+	service, entityID, imageID, tmpImagePath := msg.Service, msg.EntityID, msg.ImageID, msg.TmpImagePath // tmpImagePath - ключ временного изображения в хранилище
+	if a.closing.Load() {
+		return models.NewError(loc, service+" "+entityID+" "+imageID, models.ErrShuttingDown)
+	}
+
 	errChan := make(chan error, 1)
 
+	a.jobs.Add(1)
 	go func(ch chan<- error) {
 		//var imagePath string
+		defer a.jobs.Done()
 		defer close(ch)
 
-		img, err := a.Storage.GetRawImage(tmpImagePath)
+		img, err := a.Storage.GetRawImage(ctx, tmpImagePath)
+		if err != nil {
+			ch <- models.NewError(loc, tmpImagePath, err)
+			return
+		}
+		img, err = imageproc.Orient(ctx, img, msg.TmpOrientation)
 		if err != nil {
 			ch <- models.NewError(loc, tmpImagePath, err)
 			return
@@ -211,8 +291,17 @@ func (a *App) ProcessedSave(ctx context.Context, service, entityID, imageID, tmp
 			return
 		}
 
-		// блок кода для обработки изображений - пока просто перекрасить в серый
-		grayImg, err := toGrayScale(ctx, img)
+		// хеш считается по исходнику до конвейера, как и при проверке на дубликаты в InitialSave
+		hash, err := imageproc.DHash(ctx, img)
+		if err != nil {
+			ch <- models.NewError(loc, tmpImagePath, err)
+			return
+		}
+
+		// конвейер обработки настраивается для каждого сервиса,
+		// обрезка обложек без точки интереса от клиента ищет на изображении сам товар
+		frame := &imageproc.Frame{Image: img, Focus: msg.Focus, Smart: msg.IsCover}
+		err = a.service(service).Pipeline.Run(ctx, frame)
 		if err != nil {
 			ch <- models.NewError(loc, tmpImagePath, err)
 			return
@@ -226,10 +315,14 @@ func (a *App) ProcessedSave(ctx context.Context, service, entityID, imageID, tmp
 			if !isOK {
 				<-token
 			}
-			err := a.SC.SyncMemoryClean(ctx, serviceDirName) // сделать именованную ошибку, чтобы ещё ошибку при публикации можно было зарегистрировать
+			err := a.SC.SyncMemoryClean(ctx, service, entityID) // сделать именованную ошибку, чтобы ещё ошибку при публикации можно было зарегистрировать
 			// так эта ошибка даже нигде не читается, так что просто ЗАЛОГИРОВАТЬ
+			// в канале уже может лежать ошибка обработки - тогда не блокируемся
 			if err != nil {
-				ch <- models.NewError(loc, serviceDirName, err)
+				select {
+				case ch <- models.NewError(loc, serviceDirName, err):
+				default:
+				}
 			}
 		}()
 
@@ -238,8 +331,22 @@ func (a *App) ProcessedSave(ctx context.Context, service, entityID, imageID, tmp
 			return
 		}
 
-		imagePath, err := a.Storage.Save(ctx, service, entityID, imageID, grayImg)
+		file, err := a.Storage.Save(ctx, service, entityID, imageID, frame.Image, frame.Encode)
+		if err != nil {
+			ch <- models.NewError(loc, service+" "+entityID+" "+imageID, err)
+			return
+		}
+		imagePath := file.Key
+		bounds, original := frame.Image.Bounds(), img.Bounds()
+		entityImage := models.EntityImage{Service: service, EntityID: entityID, ImagePath: imagePath, MimeType: file.MimeType,
+			IsCover: msg.IsCover, Width: bounds.Dx(), Height: bounds.Dy(), Orientation: msg.Orientation, Quality: file.Quality, Size: file.Size,
+			PHash: &hash, DuplicateOf: msg.DuplicateOf, BlurHash: frame.BlurHash, DominantColor: frame.DominantColor,
+			OriginalWidth: original.Dx(), OriginalHeight: original.Dy(), ColorConversion: msg.ColorConversion, Palette: frame.Palette}
+
+		// производные размеры и запасные форматы лежат рядом с основным изображением: <imageID>_<name>
+		entityImage.Variants, err = a.saveVariants(ctx, service, entityID, imageID, frame)
 		if err != nil {
+			a.Storage.Delete(service, entityID, imagePath)
 			ch <- models.NewError(loc, service+" "+entityID+" "+imageID, err)
 			return
 		}
@@ -249,14 +356,31 @@ func (a *App) ProcessedSave(ctx context.Context, service, entityID, imageID, tmp
 		/*
 			defer func() {
 				if ctx.Err() != nil || err != nil {
-					a.Storage.Delete(imagePath)
+					a.Storage.Delete(service, entityID, imagePath)
 				}
 			}()
 		*/
 
 		// ТУТ ДОБАВЛЯЕТСЯ ИНФОРМАЦИЯ О ПУТИ К ИЗОБРАЖЕНИЮ В СООТВ. ТАБЛИЦУ СЕРВИСА
 
-		err = a.DB.AddImage(ctx, models.EntityImage{Service: service, EntityID: entityID, ImagePath: imagePath, IsCover: isCover})
+		err = a.DB.AddImage(ctx, entityImage)
+		release := func() {
+			for _, v := range entityImage.Variants {
+				a.Storage.Delete(service, entityID, v.Path)
+			}
+			a.Storage.Delete(service, entityID, imagePath)
+		}
+		switch {
+		case errors.Is(err, models.ErrUniqueViolation) && file.Shared:
+			// режим cas: у сущности уже есть изображение с теми же байтами или сообщение пришло повторно -
+			// запись в БД уже есть, снимаются только лишние ссылки на файлы
+			release()
+			err = nil
+		case err != nil && !errors.Is(err, models.ErrUniqueViolation):
+			// записи в БД нет, и файлы никто не удалит. В режиме cas повтор иначе добавил бы
+			// еще по ссылке, и счетчики никогда не дошли бы до нуля
+			release()
+		}
 		if err != nil {
 			ch <- models.NewError(loc, imagePath, err)
 			// DoRetry
@@ -289,9 +413,78 @@ func (a *App) ProcessedSave(ctx context.Context, service, entityID, imageID, tmp
 	}
 }
 
+// saveVariants сохраняет все варианты сервиса и запасные копии основного изображения
+// в других форматах (вариант с именем формата), а при ошибке удаляет уже сохраненные
+func (a *App) saveVariants(ctx context.Context, service, entityID, imageID string, frame *imageproc.Frame) ([]models.ImageVariant, error) {
+	loc := "App.saveVariants"
+	var variants []models.ImageVariant
+	save := func(name string, img image.Image, opts models.EncodeOptions) error {
+		file, err := a.Storage.Save(ctx, service, entityID, imageID+"_"+name, img, opts)
+		if err != nil {
+			return models.NewError(loc, imageID+" "+name, err)
+		}
+		b := img.Bounds()
+		variants = append(variants, models.ImageVariant{Name: name, Path: file.Key, MimeType: file.MimeType,
+			Width: b.Dx(), Height: b.Dy(), Quality: file.Quality, Size: file.Size})
+		return nil
+	}
+	err := func() error {
+		for _, v := range a.service(service).Variants {
+			img, err := v.Render(ctx, frame)
+			if err != nil {
+				return models.NewError(loc, imageID+" "+v.Name, err)
+			}
+			opts := frame.Encode
+			if v.Quality > 0 {
+				opts.Quality = v.Quality
+			}
+			if v.MaxBytes > 0 {
+				opts.MaxBytes = v.MaxBytes
+			}
+			if v.MinQuality > 0 {
+				opts.MinQuality = v.MinQuality
+			}
+			if err = save(v.Name, img, opts); err != nil {
+				return err
+			}
+		}
+		for _, format := range frame.Fallback {
+			// качество и бюджет из encode относятся к jpeg, webp кодируется без потерь
+			opts := frame.Encode
+			opts.Format = format
+			if err := save(format, frame.Image, opts); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		for _, saved := range variants {
+			a.Storage.Delete(service, entityID, saved.Path)
+		}
+		return nil, err
+	}
+	return variants, nil
+}
+
 func (a *App) DeleteImage(ctx context.Context, service, entityID, imagePath string) error {
 	loc := "App.DeleteImage"
-	err := a.Storage.Delete(imagePath)
+	// ключ приходит от клиента: файлы трогаются, только если у этой сущности есть такое изображение,
+	// иначе можно было бы удалить файл чужой сущности
+	images, err := a.DB.GetImageList(ctx, service, entityID)
+	if err != nil {
+		return models.NewError(loc, imagePath, err)
+	}
+	i := slices.IndexFunc(images, func(image models.EntityImage) bool { return image.ImagePath == imagePath })
+	if i < 0 {
+		return models.NewError(loc, imagePath, models.ErrNotFound)
+	}
+	for _, v := range images[i].Variants {
+		if err := a.Storage.Delete(service, entityID, v.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return models.NewError(loc, v.Path, err)
+		}
+	}
+	err = a.Storage.Delete(service, entityID, imagePath)
 	if err != nil {
 		return models.NewError(loc, imagePath, err)
 	}
@@ -334,6 +527,7 @@ func (a *App) SetBusyStatus(ctx context.Context, service, entityID string) (bool
 	if err != nil {
 		return false, models.NewError(loc, service+" "+entityID, err)
 	}
+	a.SC.MarkBusy(service, entityID)
 	return true, nil
 }
 
@@ -356,29 +550,70 @@ func (a *App) SetFreeStatus(ctx context.Context, service, entityID string) (bool
 	if err != nil {
 		return false, models.NewError(loc, service+" "+entityID, err)
 	}
+	a.SC.UnmarkBusy(service, entityID)
+	a.SC.ForgetUploads(service, entityID)
 	return true, nil
 }
 
-func (a *App) GetCoverImage(ctx context.Context, service, entityID string) (string, error) {
+// пути вариантов лежат в image.Variants
+func (a *App) GetCoverImage(ctx context.Context, service, entityID string) (models.EntityImage, error) {
 	loc := "App.GetCoverImage"
 	image, err := a.DB.GetCoverImage(ctx, service, entityID)
 	if err != nil {
-		return "", models.NewError(loc, service+" "+entityID, err)
+		return models.EntityImage{}, models.NewError(loc, service+" "+entityID, err)
 	}
-	return image.ImagePath, nil
+	return image, nil
 }
 
-func (a *App) GetImageList(ctx context.Context, service, entityID string) ([]string, error) {
+func (a *App) GetImageList(ctx context.Context, service, entityID string) ([]models.EntityImage, error) {
 	loc := "App.GetImageList"
 	images, err := a.DB.GetImageList(ctx, service, entityID)
 	if err != nil {
 		return nil, models.NewError(loc, service+" "+entityID, err)
 	}
-	urls := make([]string, 0, 10)
-	for _, image := range images {
-		urls = append(urls, image.ImagePath)
+	return images, nil
+}
+
+// FindEntitiesByColor - id сущностей сервиса, на изображениях которых есть цвет name
+// (из imageproc.ColorNames) в доле не меньше minShare, постранично после id after
+func (a *App) FindEntitiesByColor(ctx context.Context, service, name string, minShare float64, after string, limit int) ([]string, error) {
+	loc := "App.FindEntitiesByColor"
+	ids, err := a.DB.FindEntitiesByColor(ctx, service, name, minShare, after, limit)
+	if err != nil {
+		return nil, models.NewError(loc, service+" "+name, err)
+	}
+	return ids, nil
+}
+
+// Shutdown вызывается, когда gRPC сервер и консьюмер уже остановлены:
+// новые сохранения больше не принимаются, ждем фоновые горутины (не дольше ctx),
+// после чего снимаем busy статусы, которые этот экземпляр выставил и не успел снять
+func (a *App) Shutdown(ctx context.Context) error {
+	loc := "App.Shutdown"
+	a.closing.Store(true)
+
+	var errs []error
+	done := make(chan struct{})
+	go func() {
+		a.jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, models.NewError(loc, "waiting for jobs", ctx.Err()))
+	}
+
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	for _, e := range a.SC.BusyEntities() {
+		if err := a.DB.SetStatus(releaseCtx, e.Service, e.EntityID, ImageStatusFree); err != nil {
+			errs = append(errs, models.NewError(loc, e.Service+" "+e.EntityID, err))
+			continue
+		}
+		a.SC.UnmarkBusy(e.Service, e.EntityID)
 	}
-	return urls, nil
+	return errors.Join(errs...)
 }
 
 // где добавить и использовать методы обновления БД?
@@ -0,0 +1,118 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/glekoz/online-shop_image/data/storage"
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// fakeRefs считает ссылки на файлы в памяти
+type fakeRefs struct {
+	mu   sync.Mutex
+	refs map[models.BlobRef]int // ключ с нулевым Count
+}
+
+func (r *fakeRefs) AddBlobRef(ctx context.Context, ref models.BlobRef) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := ref.Count
+	ref.Count = 0
+	r.refs[ref] += n
+	return nil
+}
+
+func (r *fakeRefs) ReleaseBlobRef(ctx context.Context, ref models.BlobRef) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := ref.Count
+	ref.Count = 0
+	if r.refs[ref] == 0 {
+		return 0, models.ErrNotFound
+	}
+	r.refs[ref] -= n
+	if r.refs[ref] <= 0 {
+		delete(r.refs, ref)
+	}
+	left := 0
+	for other, count := range r.refs {
+		if other.Key == ref.Key {
+			left += count
+		}
+	}
+	return left, nil
+}
+
+func (r *fakeRefs) GetEntityBlobRefs(ctx context.Context, service, entityID string) ([]models.BlobRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var refs []models.BlobRef
+	for ref, count := range r.refs {
+		if ref.Service == service && ref.EntityID == entityID {
+			ref.Count = count
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+func TestProcessedSaveReleasesRefsOnDBError(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	services, err := NewServices(map[string]config.Service{"product": {
+		Pipeline: []config.Step{{Type: "encode", Format: "jpeg", Fallback: []string{"webp"}}},
+		Variants: []config.Variant{{Name: "thumb", Width: 8, Height: 8}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := &fakeRefs{refs: make(map[models.BlobRef]int)}
+	db, queue := &fakeDB{}, &fakeAMT{}
+	app := NewApp(db, storage.NewCASStorage(storage.Storage{Path: root, Quality: 90}, refs, 90), queue, services)
+
+	data := gpsJPEG(t)
+	decoded, err := app.DecodeImage(ctx, "product", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = app.InitialSave(ctx, "product", "42", true, data, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+	var msg models.ProcessImageMessage
+	if err = json.Unmarshal(queue.msgs[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// каждый повтор после ошибки БД не должен оставлять ссылок на готовые файлы
+	// (временная копия лежит у сущности 42/tmp)
+	db.addErr = errors.New("connection reset")
+	for range 3 {
+		if err = app.ProcessedSave(ctx, msg); err == nil {
+			t.Fatal("want the database error")
+		}
+		left, _ := refs.GetEntityBlobRefs(ctx, "product", "42")
+		if len(left) != 0 {
+			t.Fatalf("refs after failed AddImage: %+v", left)
+		}
+	}
+
+	db.addErr = nil
+	if err = app.ProcessedSave(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	saved := db.images[0]
+	keys := []string{saved.ImagePath}
+	for _, v := range saved.Variants {
+		keys = append(keys, v.Path)
+	}
+	for _, key := range keys {
+		if n := refs.refs[models.BlobRef{Service: "product", EntityID: "42", Key: key}]; n != 1 {
+			t.Fatalf("%s: %d refs, want 1", key, n)
+		}
+	}
+}
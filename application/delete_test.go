@@ -0,0 +1,39 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/glekoz/online-shop_image/data/storage"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+func TestDeleteImageForeignKey(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	local := storage.Storage{Path: root, Quality: 90}
+	db := &fakeDB{}
+	app := NewApp(db, local, &fakeAMT{}, nil)
+
+	file, err := local.Save(ctx, "product", "7", "img", image.NewGray(image.Rect(0, 0, 4, 4)), models.EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.images = append(db.images, models.EntityImage{Service: "product", EntityID: "7", ImagePath: file.Key})
+
+	// у сущности 42 такого изображения нет: файл сущности 7 остается
+	if err = app.DeleteImage(ctx, "product", "42", file.Key); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("err = %v, want models.ErrNotFound", err)
+	}
+	if _, err = os.Stat(filepath.Join(root, file.Key)); err != nil {
+		t.Fatal(err)
+	}
+	// и хранилище само не удаляет ключ вне <service>/<entityID>/
+	if err = local.Delete("product", "42", file.Key); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("storage: err = %v, want models.ErrNotFound", err)
+	}
+}
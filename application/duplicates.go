@@ -0,0 +1,45 @@
+package application
+
+import (
+	"context"
+	"image"
+
+	"github.com/glekoz/online-shop_image/internal/imageproc"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// checkDuplicate сравнивает перцептивный хеш загрузки с сохраненными изображениями сущности
+// и с уже принятыми в этом стриме (их еще нет в БД). При политике reject похожее изображение -
+// *models.DuplicateError, при flag возвращается ключ или id похожего, при off проверки нет
+func (a *App) checkDuplicate(ctx context.Context, service, entityID, imageID string, img image.Image) (string, error) {
+	loc := "App.checkDuplicate"
+	policy := a.service(service).Duplicates
+	if policy.Policy == DuplicatesOff {
+		return "", nil
+	}
+	hash, err := imageproc.DHash(ctx, img)
+	if err != nil {
+		return "", models.NewError(loc, imageID, err)
+	}
+	saved, err := a.DB.GetImageHashes(ctx, service, entityID)
+	if err != nil {
+		return "", models.NewError(loc, service+" "+entityID, err)
+	}
+	match, best := "", policy.MaxDistance+1
+	for _, h := range saved {
+		if d := imageproc.HashDistance(h.PHash, hash); d < best {
+			match, best = h.ImagePath, d
+		}
+	}
+	reject := policy.Policy == DuplicatesReject
+	if match == "" || !reject {
+		// отклоненное изображение не запоминается - с ним не сравниваются следующие
+		if upload := a.SC.MatchUpload(service, entityID, imageID, hash, policy.MaxDistance, !reject); match == "" {
+			match = upload
+		}
+	}
+	if match != "" && reject {
+		return "", models.NewError(loc, imageID, &models.DuplicateError{Of: match})
+	}
+	return match, nil
+}
@@ -0,0 +1,201 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/glekoz/online-shop_image/data/storage"
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/imageproc"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// fakeDB - только то, что нужно InitialSave и ProcessedSave, остальные методы не вызываются
+type fakeDB struct {
+	DBAPI
+	mu     sync.Mutex
+	images []models.EntityImage
+	addErr error // если задана, AddImage ничего не записывает и возвращает ее
+}
+
+func (db *fakeDB) AddImage(ctx context.Context, image models.EntityImage) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.addErr != nil {
+		return db.addErr
+	}
+	db.images = append(db.images, image)
+	return nil
+}
+
+func (db *fakeDB) GetImageList(ctx context.Context, service, entityID string) ([]models.EntityImage, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var images []models.EntityImage
+	for _, image := range db.images {
+		if image.Service == service && image.EntityID == entityID {
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+func (db *fakeDB) SetStatus(ctx context.Context, service, entityID, status string) error {
+	return nil
+}
+
+func (db *fakeDB) GetImageHashes(ctx context.Context, service, entityID string) ([]models.ImageHash, error) {
+	return nil, nil
+}
+
+type fakeAMT struct {
+	msgs [][]byte
+}
+
+func (q *fakeAMT) Publish(ctx context.Context, msg []byte) error {
+	q.msgs = append(q.msgs, msg)
+	return nil
+}
+
+// gpsJPEG - JPEG 24x16 с EXIF: Orientation 6 и GPS IFD со строкой GPS-SECRET
+func gpsJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := range 16 {
+		for x := range 24 {
+			img.Set(x, y, color.NRGBA{uint8(x * 10), uint8(y * 15), 90, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	tiff := []byte("II\x2a\x00\x08\x00\x00\x00")
+	tiff = le.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x12, 0x01, 3, 0) // Orientation, SHORT
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, imageproc.OrientationRotate90)
+	tiff = append(tiff, 0x25, 0x88, 4, 0) // GPSInfo, LONG
+	tiff = le.AppendUint32(tiff, 1)
+	tiff = le.AppendUint32(tiff, 38)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = le.AppendUint16(tiff, 1)
+	tiff = append(tiff, 0x1b, 0x00, 2, 0) // GPSProcessingMethod
+	tiff = le.AppendUint32(tiff, 11)
+	tiff = le.AppendUint32(tiff, 56)
+	tiff = le.AppendUint32(tiff, 0)
+	tiff = append(tiff, "GPS-SECRET\x00"...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(payload)+2))
+	app1 = append(app1, payload...)
+	data := buf.Bytes()
+	return append(append(append([]byte{}, data[:2]...), app1...), data[2:]...)
+}
+
+// hasExif - есть ли в файле сегмент APP1 Exif (JPEG) или чанк EXIF/eXIf (WebP, PNG)
+func hasExif(data []byte) bool {
+	for i := 0; i+10 <= len(data); i++ {
+		if data[i] == 0xff && data[i+1] == 0xe1 && bytes.Equal(data[i+4:i+10], []byte("Exif\x00\x00")) {
+			return true
+		}
+	}
+	return bytes.Contains(data, []byte("EXIF")) || bytes.Contains(data, []byte("eXIf"))
+}
+
+// storedFiles читает все файлы под корнем хранилища
+func storedFiles(t *testing.T, root string) map[string][]byte {
+	t.Helper()
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		files[path] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestStoredFilesHaveNoMetadata(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	services, err := NewServices(map[string]config.Service{"product": {
+		Pipeline: []config.Step{{Type: "encode", Format: "jpeg", Fallback: []string{"webp"}}},
+		Variants: []config.Variant{{Name: "thumb", Width: 8, Height: 8}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, queue := &fakeDB{}, &fakeAMT{}
+	app := NewApp(db, storage.Storage{Path: root, Quality: 90}, queue, services)
+
+	data := gpsJPEG(t)
+	decoded, err := app.DecodeImage(ctx, "product", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Orientation != imageproc.OrientationRotate90 {
+		t.Fatalf("fixture orientation %d", decoded.Orientation)
+	}
+	if _, err = app.InitialSave(ctx, "product", "42", true, data, decoded, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// временная копия удаляется после обработки, поэтому проверяется сразу
+	tmp := storedFiles(t, root)
+	if len(tmp) != 1 {
+		t.Fatalf("%d files after InitialSave, want the tmp copy", len(tmp))
+	}
+	for path, file := range tmp {
+		if hasExif(file) || bytes.Contains(file, []byte("GPS-SECRET")) {
+			t.Errorf("%s keeps EXIF", path)
+		}
+	}
+
+	if len(queue.msgs) != 1 {
+		t.Fatalf("%d messages published", len(queue.msgs))
+	}
+	var msg models.ProcessImageMessage
+	if err = json.Unmarshal(queue.msgs[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if err = app.ProcessedSave(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// основное изображение, thumb и запасная копия webp
+	stored := storedFiles(t, root)
+	if len(stored) != 3 {
+		t.Fatalf("%d files after ProcessedSave, want 3", len(stored))
+	}
+	for path, file := range stored {
+		if hasExif(file) || bytes.Contains(file, []byte("GPS-SECRET")) {
+			t.Errorf("%s keeps EXIF", path)
+		}
+	}
+	// поворот из EXIF применен, хотя тега во временной копии уже не было
+	if len(db.images) != 1 {
+		t.Fatalf("%d images in the database", len(db.images))
+	}
+	saved := db.images[0]
+	if saved.Width != 16 || saved.Height != 24 || saved.Orientation != imageproc.OrientationRotate90 {
+		t.Fatalf("saved %dx%d orientation %d, want 16x24 orientation 6", saved.Width, saved.Height, saved.Orientation)
+	}
+}
@@ -2,26 +2,129 @@ package application
 
 import (
 	"context"
-	"image"
-	"image/color"
+	"errors"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/imageproc"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// для сервисов без настроек остается прежнее поведение - перекрасить в серый
+var defaultPipeline = imageproc.NewPipeline(imageproc.Grayscale{})
+
+// политики для похожих изображений одной сущности
+const (
+	DuplicatesOff    = "off"    // хеш только сохраняется
+	DuplicatesFlag   = "flag"   // изображение сохраняется, клиент получает предупреждение
+	DuplicatesReject = "reject" // изображение не сохраняется
+)
+
+// при каком расстоянии хешей изображения считаются похожими, если в настройках не задано
+const defaultMaxHashDistance = 10
+
+// политики для загрузок низкого качества
+const (
+	QualityOff    = "off"    // метрики не считаются
+	QualityWarn   = "warn"   // изображение сохраняется, клиент получает предупреждение
+	QualityReject = "reject" // изображение не сохраняется
 )
 
-// Добавить контекст???
-func toGrayScale(ctx context.Context, img image.Image) (image.Image, error) {
-	bounds := img.Bounds()
-	grayImg := image.NewGray(bounds)
+// пороги качества, если в настройках не заданы
+var defaultQualityLimits = imageproc.QualityLimits{MinSharpness: 50, MinBrightness: 40, MaxUniformity: 0.98}
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		if y%500 == 0 {
-			if ctx.Err() != nil {
-				return nil, ctx.Err()
+// Service - как обрабатываются изображения конкретного сервиса (товары, аватары пользователей)
+type Service struct {
+	Formats    []string
+	Limits     imageproc.Limits
+	Pipeline   *imageproc.Pipeline
+	Variants   []imageproc.Variant
+	Duplicates Duplicates
+	Quality    QualityCheck
+}
+
+type Duplicates struct {
+	Policy      string
+	MaxDistance int
+}
+
+type QualityCheck struct {
+	Policy string
+	Limits imageproc.QualityLimits
+}
+
+func NewServices(cfg map[string]config.Service) (map[string]Service, error) {
+	loc := "application.NewServices"
+	services := make(map[string]Service, len(cfg))
+	for name, sc := range cfg {
+		pipeline, err := imageproc.FromConfig(sc.Pipeline)
+		if err != nil {
+			return nil, models.NewError(loc, name, err)
+		}
+		variants, err := imageproc.VariantsFromConfig(sc.Variants)
+		if err != nil {
+			return nil, models.NewError(loc, name, err)
+		}
+		// варианты кодируются в формате конвейера
+		if pipeline.Format() == "webp" {
+			for _, v := range variants {
+				if v.Quality > 0 || v.MaxBytes > 0 || v.MinQuality > 0 {
+					return nil, models.NewError(loc, name+" "+v.Name, errors.New("quality, max_bytes and min_quality apply only to jpeg, webp is lossless"))
+				}
 			}
 		}
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			originalColor := img.At(x, y)
-			grayColor := color.GrayModel.Convert(originalColor)
-			grayImg.Set(x, y, grayColor)
+		limits := imageproc.LimitsFromConfig(sc.Limits)
+		if limits.MinWidth > limits.MaxWidth || limits.MinHeight > limits.MaxHeight {
+			return nil, models.NewError(loc, name, errors.New("limits: min is greater than max"))
+		}
+		formats := sc.Formats
+		if len(formats) == 0 {
+			formats = imageproc.DefaultFormats
+		}
+		duplicates := Duplicates{Policy: sc.Duplicates.Policy, MaxDistance: sc.Duplicates.MaxDistance}
+		if duplicates.Policy == "" {
+			duplicates.Policy = DuplicatesOff
+		}
+		if duplicates.MaxDistance == 0 {
+			duplicates.MaxDistance = defaultMaxHashDistance
+		}
+		// незаданный порог берется по умолчанию, а 0 его отключает
+		quality := QualityCheck{Policy: sc.QualityCheck.Policy, Limits: defaultQualityLimits}
+		if quality.Policy == "" {
+			quality.Policy = QualityOff
+		}
+		if sc.QualityCheck.MinSharpness != nil {
+			quality.Limits.MinSharpness = *sc.QualityCheck.MinSharpness
+		}
+		if sc.QualityCheck.MinBrightness != nil {
+			quality.Limits.MinBrightness = *sc.QualityCheck.MinBrightness
 		}
+		if sc.QualityCheck.MaxUniformity != nil {
+			quality.Limits.MaxUniformity = *sc.QualityCheck.MaxUniformity
+		}
+		services[name] = Service{Formats: formats, Limits: limits, Pipeline: pipeline, Variants: variants, Duplicates: duplicates,
+			Quality: quality}
+	}
+	return services, nil
+}
+
+func (a *App) service(name string) Service {
+	if s, ok := a.Services[name]; ok {
+		return s
+	}
+	return Service{Formats: imageproc.DefaultFormats, Limits: imageproc.DefaultLimits, Pipeline: defaultPipeline,
+		Duplicates: Duplicates{Policy: DuplicatesOff, MaxDistance: defaultMaxHashDistance},
+		Quality:    QualityCheck{Policy: QualityOff, Limits: defaultQualityLimits}}
+}
+
+// DecodeImage декодирует загрузку, проверив формат и размеры по настройкам сервиса.
+// Неразрешенный формат - *imageproc.FormatError внутри ошибки,
+// нарушение ограничений - *imageproc.LimitError
+func (a *App) DecodeImage(ctx context.Context, service string, data []byte) (imageproc.Decoded, error) {
+	loc := "App.DecodeImage"
+	s := a.service(service)
+	decoded, err := imageproc.Decode(ctx, data, s.Formats, s.Limits)
+	if err != nil {
+		return imageproc.Decoded{}, models.NewError(loc, service, err)
 	}
-	return grayImg, nil
+	return decoded, nil
 }
@@ -0,0 +1,29 @@
+package application
+
+import (
+	"context"
+	"image"
+
+	"github.com/glekoz/online-shop_image/internal/imageproc"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// checkQuality проверяет резкость, яркость и однотонность загрузки по порогам сервиса.
+// При политике reject нарушение - *models.LowQualityError, при warn возвращаются причины,
+// при off метрики не считаются
+func (a *App) checkQuality(ctx context.Context, service, imageID string, img image.Image) ([]string, error) {
+	loc := "App.checkQuality"
+	check := a.service(service).Quality
+	if check.Policy == QualityOff {
+		return nil, nil
+	}
+	metrics, err := imageproc.Measure(ctx, img)
+	if err != nil {
+		return nil, models.NewError(loc, imageID, err)
+	}
+	reasons := check.Limits.Check(metrics)
+	if len(reasons) > 0 && check.Policy == QualityReject {
+		return nil, models.NewError(loc, imageID, &models.LowQualityError{Reasons: reasons})
+	}
+	return reasons, nil
+}
@@ -7,9 +7,16 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/glekoz/online-shop_image/internal/imageproc"
 	"github.com/glekoz/online-shop_image/internal/models"
 )
 
+// EntityKey - сущность, для которой этот экземпляр выставил busy статус
+type EntityKey struct {
+	Service  string
+	EntityID string
+}
+
 type SyncController struct {
 	DB                DBAPI
 	Storage           StorageAPI
@@ -20,6 +27,16 @@ type SyncController struct {
 	ProcessCount      map[string]int
 	DirSyncMutex      sync.RWMutex
 	DirSync           map[string]chan struct{}
+	BusyMutex         sync.Mutex
+	Busy              map[EntityKey]struct{} // снимаются при остановке сервиса, если их не сняли штатно
+	UploadsMutex      sync.Mutex
+	Uploads           map[EntityKey][]UploadHash // хеши изображений текущего стрима, которых еще нет в БД
+}
+
+// UploadHash - перцептивный хеш изображения, загруженного в текущем стриме
+type UploadHash struct {
+	ImageID string
+	Hash    uint64
 }
 
 func NewSyncController(db DBAPI, storage StorageAPI) *SyncController {
@@ -27,8 +44,10 @@ func NewSyncController(db DBAPI, storage StorageAPI) *SyncController {
 	reqCount := make(map[string]int)
 	processCount := make(map[string]int)
 	dirSync := make(map[string]chan struct{})
+	busy := make(map[EntityKey]struct{})
+	uploads := make(map[EntityKey][]UploadHash)
 	return &SyncController{DB: db, Storage: storage,
-		ImageCount: imageCount, ReqCount: reqCount, ProcessCount: processCount, DirSync: dirSync}
+		ImageCount: imageCount, ReqCount: reqCount, ProcessCount: processCount, DirSync: dirSync, Busy: busy, Uploads: uploads}
 }
 
 /*
@@ -61,31 +80,36 @@ func (sc *SyncController) ProcessCountIncrement(dir string) {
 	sc.ProcessCount[dir]++
 }
 
-func (sc *SyncController) SyncMemoryClean(ctx context.Context, dir string) error {
-	sc.ProcessCountMutex.RLock()
-	sc.ReqCountMutex.RLock()
+func (sc *SyncController) SyncMemoryClean(ctx context.Context, service, entityID string) error {
+	dir := filepath.Join(service, entityID)
+	sc.ProcessCountMutex.Lock()
+	sc.ReqCountMutex.Lock()
 	defer func() {
-		sc.ReqCountMutex.RUnlock()
-		sc.ProcessCountMutex.RUnlock()
+		sc.ReqCountMutex.Unlock()
+		sc.ProcessCountMutex.Unlock()
 	}()
 	var err error
 
 	if sc.ProcessCount[dir] == sc.ReqCount[dir] {
-		service := strings.ToLower(filepath.SplitList(dir)[0])
-		entityID := filepath.SplitList(dir)[1]
 		// count := sc.ProcessCount[dir]
 		// ПРИ ПОЛУЧЕНИИ ЭТОГО СООБЩЕНИЯ ОБНОВЛЯЕТСЯ СТОЛБИК С КОЛИЧЕСТВОМ ИЗОБРАЖЕНИЙ В СЕРВИСЕ
-		err1 := sc.DB.SetStatus(ctx, service, entityID, ImageStatusFree)
+		err1 := sc.DB.SetStatus(ctx, strings.ToLower(service), entityID, ImageStatusFree)
 		// ретраить из-за моментальных сетевых ошибок
-		err2 := sc.Storage.DeleteAll(service, entityID)
+		// удаляется только папка с временными изображениями, обработанные остаются
+		err2 := sc.Storage.DeleteAll(service, filepath.Join(entityID, "tmp"))
 		err = errors.Join(err1, err2)
 
 		// close(sc.DirSync[dir]) // хз, но пусть будет - закрывает канал тот, кто в него пишет
+		sc.DirSyncMutex.Lock()
 		delete(sc.DirSync, dir)
+		sc.DirSyncMutex.Unlock()
 		delete(sc.ProcessCount, dir)
 		delete(sc.ReqCount, dir)
 	}
-	return models.NewError("SyncMemoryClean", dir, err)
+	if err != nil {
+		return models.NewError("SyncMemoryClean", dir, err)
+	}
+	return nil
 }
 
 func (sc *SyncController) DirSyncChannel(dir string) chan struct{} {
@@ -116,3 +140,68 @@ func (sc *SyncController) ReqCountIncrement(req string) {
 	defer sc.ReqCountMutex.Unlock()
 	sc.ReqCount[req]++
 }
+
+func (sc *SyncController) MarkBusy(service, entityID string) {
+	sc.BusyMutex.Lock()
+	defer sc.BusyMutex.Unlock()
+	sc.Busy[EntityKey{Service: service, EntityID: entityID}] = struct{}{}
+}
+
+func (sc *SyncController) UnmarkBusy(service, entityID string) {
+	sc.BusyMutex.Lock()
+	defer sc.BusyMutex.Unlock()
+	delete(sc.Busy, EntityKey{Service: service, EntityID: entityID})
+}
+
+func (sc *SyncController) BusyEntities() []EntityKey {
+	sc.BusyMutex.Lock()
+	defer sc.BusyMutex.Unlock()
+	entities := make([]EntityKey, 0, len(sc.Busy))
+	for e := range sc.Busy {
+		entities = append(entities, e)
+	}
+	return entities
+}
+
+// MatchUpload ищет среди изображений текущего стрима сущности ближайшее к hash не дальше maxDistance
+// и возвращает его id ("" - похожих нет). Новое изображение запоминается, если оно не дубликат
+// или keepDuplicate. Поиск и запись под одним мьютексом: изображения стрима сохраняются параллельно
+func (sc *SyncController) MatchUpload(service, entityID, imageID string, hash uint64, maxDistance int, keepDuplicate bool) string {
+	key := EntityKey{Service: service, EntityID: entityID}
+	sc.UploadsMutex.Lock()
+	defer sc.UploadsMutex.Unlock()
+	match, best := "", maxDistance+1
+	for _, u := range sc.Uploads[key] {
+		if d := imageproc.HashDistance(u.Hash, hash); d < best {
+			match, best = u.ImageID, d
+		}
+	}
+	if match == "" || keepDuplicate {
+		sc.Uploads[key] = append(sc.Uploads[key], UploadHash{ImageID: imageID, Hash: hash})
+	}
+	return match
+}
+
+// ForgetUpload убирает изображение, которое так и не удалось сохранить
+func (sc *SyncController) ForgetUpload(service, entityID, imageID string) {
+	key := EntityKey{Service: service, EntityID: entityID}
+	sc.UploadsMutex.Lock()
+	defer sc.UploadsMutex.Unlock()
+	uploads := sc.Uploads[key]
+	for i, u := range uploads {
+		if u.ImageID == imageID {
+			sc.Uploads[key] = append(uploads[:i], uploads[i+1:]...)
+			break
+		}
+	}
+	if len(sc.Uploads[key]) == 0 {
+		delete(sc.Uploads, key)
+	}
+}
+
+// ForgetUploads вызывается, когда стрим сущности закончился
+func (sc *SyncController) ForgetUploads(service, entityID string) {
+	sc.UploadsMutex.Lock()
+	defer sc.UploadsMutex.Unlock()
+	delete(sc.Uploads, EntityKey{Service: service, EntityID: entityID})
+}
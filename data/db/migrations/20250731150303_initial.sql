@@ -6,8 +6,8 @@ CREATE TABLE entity_state (
     image_count INTEGER NOT NULL,
     status VARCHAR(50) NOT NULL,
     max_count INTEGER NOT NULL,
-    PRIMARY KEY (service_name, entity_id)
-) PARTITION BY LIST (service_name);
+    PRIMARY KEY (service, entity_id)
+) PARTITION BY LIST (service);
 
 CREATE INDEX entity_id_idx ON entity_state (entity_id);
 
@@ -21,12 +21,13 @@ FOR VALUES IN ('product');
 CREATE TABLE entity_image_list (
     service VARCHAR(50) NOT NULL,
     entity_id VARCHAR(100) NOT NULL,
-    image_path VARCHAR(200) PRIMARY KEY, -- возможно стоит поменять первичный ключ на другой
+    image_path VARCHAR(200) NOT NULL,
     is_cover BOOLEAN NOT NULL,
-    FOREIGN KEY (service_name, entity_id) 
-        REFERENCES entity_state(service_name, entity_id)
+    PRIMARY KEY (service, image_path), -- в ключе секционированной таблицы должен быть столбец секционирования
+    FOREIGN KEY (service, entity_id) 
+        REFERENCES entity_state(service, entity_id)
         ON DELETE CASCADE
-) PARTITION BY LIST (service_name);
+) PARTITION BY LIST (service);
 
 CREATE TABLE user_image_list PARTITION OF entity_image_list
 FOR VALUES IN ('user');
@@ -37,12 +38,12 @@ FOR VALUES IN ('product');
 
 -- +goose Down
 -- +goose StatementBegin
-DROP TABLE user_state;
-DROP TABLE product_state;
-DROP TABLE entity_state;
-DROP INDEX entity_id_idx;
-
 DROP TABLE user_image_list;
 DROP TABLE product_image_list;
 DROP TABLE entity_image_list;
+
+DROP INDEX entity_id_idx;
+DROP TABLE user_state;
+DROP TABLE product_state;
+DROP TABLE entity_state;
 -- +goose StatementEnd
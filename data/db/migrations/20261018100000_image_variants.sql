@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE entity_image_list
+    ADD COLUMN width INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+
+-- производные размеры (миниатюра для списка, карточка, зум), лежат рядом с основным изображением
+CREATE TABLE entity_image_variant (
+    service VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(100) NOT NULL,
+    image_path VARCHAR(200) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    variant_path VARCHAR(200) NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    PRIMARY KEY (service, image_path, name),
+    FOREIGN KEY (service, image_path)
+        REFERENCES entity_image_list(service, image_path)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX entity_image_variant_entity_idx ON entity_image_variant (service, entity_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX entity_image_variant_entity_idx;
+DROP TABLE entity_image_variant;
+
+ALTER TABLE entity_image_list
+    DROP COLUMN width,
+    DROP COLUMN height;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- исходный тег EXIF Orientation (1-8), 0 - тега не было или изображение загружено раньше
+ALTER TABLE entity_image_list
+    ADD COLUMN orientation SMALLINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_list
+    DROP COLUMN orientation;
+-- +goose StatementEnd
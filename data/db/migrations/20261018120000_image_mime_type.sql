@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- до появления колонки хранилище писало только JPEG
+ALTER TABLE entity_image_list
+    ADD COLUMN mime_type VARCHAR(50) NOT NULL DEFAULT 'image/jpeg';
+
+ALTER TABLE entity_image_variant
+    ADD COLUMN mime_type VARCHAR(50) NOT NULL DEFAULT 'image/jpeg';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_variant
+    DROP COLUMN mime_type;
+
+ALTER TABLE entity_image_list
+    DROP COLUMN mime_type;
+-- +goose StatementEnd
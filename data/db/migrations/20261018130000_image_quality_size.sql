@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- итоговое качество JPEG (0 - без потерь или не записано) и размер файла в байтах (0 - не записан)
+ALTER TABLE entity_image_list
+    ADD COLUMN quality SMALLINT NOT NULL DEFAULT 0,
+    ADD COLUMN size_bytes BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE entity_image_variant
+    ADD COLUMN quality SMALLINT NOT NULL DEFAULT 0,
+    ADD COLUMN size_bytes BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_variant
+    DROP COLUMN quality,
+    DROP COLUMN size_bytes;
+
+ALTER TABLE entity_image_list
+    DROP COLUMN quality,
+    DROP COLUMN size_bytes;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- перцептивный хеш (dHash) исходника, NULL - загружено до его подсчета,
+-- и путь или id похожего изображения той же сущности на момент загрузки ('' - не дубликат)
+ALTER TABLE entity_image_list
+    ADD COLUMN phash BIGINT,
+    ADD COLUMN duplicate_of VARCHAR(200) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_list
+    DROP COLUMN phash,
+    DROP COLUMN duplicate_of;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- размытая заглушка для фронтенда: BlurHash и доминирующий цвет "#rrggbb", '' - не посчитаны
+ALTER TABLE entity_image_list
+    ADD COLUMN blurhash VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN dominant_color VARCHAR(7) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_list
+    DROP COLUMN blurhash,
+    DROP COLUMN dominant_color;
+-- +goose StatementEnd
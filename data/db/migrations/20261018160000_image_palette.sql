@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- основные цвета изображения для фильтра по цвету в каталоге, name - из фиксированного набора
+CREATE TABLE entity_image_palette (
+    service VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(100) NOT NULL,
+    image_path VARCHAR(200) NOT NULL,
+    position SMALLINT NOT NULL,
+    color VARCHAR(7) NOT NULL,
+    name VARCHAR(20) NOT NULL,
+    share REAL NOT NULL,
+    PRIMARY KEY (service, image_path, position),
+    FOREIGN KEY (service, image_path)
+        REFERENCES entity_image_list(service, image_path)
+        ON DELETE CASCADE
+);
+
+CREATE INDEX entity_image_palette_name_idx ON entity_image_palette (service, name, entity_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX entity_image_palette_name_idx;
+DROP TABLE entity_image_palette;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- размеры загруженного изображения после поворота, до обрезки и полей конвейера:
+-- по ним фронтенд знает исходное соотношение сторон, 0 - загружено до их учета
+ALTER TABLE entity_image_list
+    ADD COLUMN original_width INT NOT NULL DEFAULT 0,
+    ADD COLUMN original_height INT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_list
+    DROP COLUMN original_width,
+    DROP COLUMN original_height;
+-- +goose StatementEnd
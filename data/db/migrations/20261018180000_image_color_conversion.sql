@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- какое преобразование в sRGB применено при загрузке: cmyk, adobe-rgb, display-p3,
+-- '' - изображение уже было в sRGB или загружено до учета профилей
+ALTER TABLE entity_image_list
+    ADD COLUMN color_conversion VARCHAR(20) NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE entity_image_list
+    DROP COLUMN color_conversion;
+-- +goose StatementEnd
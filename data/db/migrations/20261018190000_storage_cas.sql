@@ -0,0 +1,63 @@
+-- +goose Up
+-- +goose StatementBegin
+-- в режиме cas один файл может быть изображением нескольких сущностей, поэтому путь
+-- уникален только в пределах сущности
+ALTER TABLE entity_image_variant DROP CONSTRAINT entity_image_variant_service_image_path_fkey;
+ALTER TABLE entity_image_palette DROP CONSTRAINT entity_image_palette_service_image_path_fkey;
+
+ALTER TABLE entity_image_list DROP CONSTRAINT entity_image_list_pkey;
+ALTER TABLE entity_image_list ADD PRIMARY KEY (service, entity_id, image_path);
+
+ALTER TABLE entity_image_variant DROP CONSTRAINT entity_image_variant_pkey;
+ALTER TABLE entity_image_variant ADD PRIMARY KEY (service, entity_id, image_path, name);
+ALTER TABLE entity_image_variant ADD FOREIGN KEY (service, entity_id, image_path)
+    REFERENCES entity_image_list(service, entity_id, image_path)
+    ON DELETE CASCADE;
+
+ALTER TABLE entity_image_palette DROP CONSTRAINT entity_image_palette_pkey;
+ALTER TABLE entity_image_palette ADD PRIMARY KEY (service, entity_id, image_path, position);
+ALTER TABLE entity_image_palette ADD FOREIGN KEY (service, entity_id, image_path)
+    REFERENCES entity_image_list(service, entity_id, image_path)
+    ON DELETE CASCADE;
+
+-- файлы хранилища в режиме cas: ref_count - сколько раз файл сохранен и еще не удален,
+-- по всем сущностям вместе
+CREATE TABLE storage_blob (
+    path VARCHAR(200) PRIMARY KEY,
+    ref_count INTEGER NOT NULL CHECK (ref_count >= 0)
+);
+
+-- ссылки на файл по сущностям: по ним DeleteAll знает, какие файлы освободить.
+-- entity_id временных файлов - <entity_id>/tmp
+CREATE TABLE storage_blob_ref (
+    service VARCHAR(50) NOT NULL,
+    entity_id VARCHAR(100) NOT NULL,
+    path VARCHAR(200) NOT NULL REFERENCES storage_blob(path) ON DELETE CASCADE,
+    ref_count INTEGER NOT NULL CHECK (ref_count >= 0),
+    PRIMARY KEY (service, entity_id, path)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE storage_blob_ref;
+DROP TABLE storage_blob;
+
+ALTER TABLE entity_image_variant DROP CONSTRAINT entity_image_variant_service_entity_id_image_path_fkey;
+ALTER TABLE entity_image_palette DROP CONSTRAINT entity_image_palette_service_entity_id_image_path_fkey;
+
+ALTER TABLE entity_image_list DROP CONSTRAINT entity_image_list_pkey;
+ALTER TABLE entity_image_list ADD PRIMARY KEY (service, image_path);
+
+ALTER TABLE entity_image_variant DROP CONSTRAINT entity_image_variant_pkey;
+ALTER TABLE entity_image_variant ADD PRIMARY KEY (service, image_path, name);
+ALTER TABLE entity_image_variant ADD FOREIGN KEY (service, image_path)
+    REFERENCES entity_image_list(service, image_path)
+    ON DELETE CASCADE;
+
+ALTER TABLE entity_image_palette DROP CONSTRAINT entity_image_palette_pkey;
+ALTER TABLE entity_image_palette ADD PRIMARY KEY (service, image_path, position);
+ALTER TABLE entity_image_palette ADD FOREIGN KEY (service, image_path)
+    REFERENCES entity_image_list(service, image_path)
+    ON DELETE CASCADE;
+-- +goose StatementEnd
@@ -0,0 +1,63 @@
+-- +goose Up
+-- +goose StatementBegin
+-- абсолютные пути локального тома (<storage.path>/<service>/<entity_id>/<файл> и <storage.path>/cas/...)
+-- становятся ключами хранилища. Корень тома миграции неизвестен, поэтому ключ собирается заново
+-- из сервиса, сущности и имени файла. Ключи S3 уже относительные и не меняются
+CREATE FUNCTION pg_temp.storage_key(path TEXT, service TEXT, entity_id TEXT) RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN path NOT LIKE '/%' THEN path
+        WHEN path ~ '/cas/[0-9a-f]{2}/[0-9a-f]{2}/[0-9a-f]{64}\.[a-z]+$'
+            THEN substring(path FROM 'cas/[0-9a-f]{2}/[0-9a-f]{2}/[0-9a-f]{64}\.[a-z]+$')
+        ELSE service || '/' || entity_id || '/' || regexp_replace(path, '^.*/', '')
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE entity_image_variant DROP CONSTRAINT entity_image_variant_service_entity_id_image_path_fkey;
+ALTER TABLE entity_image_palette DROP CONSTRAINT entity_image_palette_service_entity_id_image_path_fkey;
+ALTER TABLE storage_blob_ref DROP CONSTRAINT storage_blob_ref_path_fkey;
+
+UPDATE entity_image_list
+SET image_path = pg_temp.storage_key(image_path, service, entity_id),
+    duplicate_of = pg_temp.storage_key(duplicate_of, service, entity_id)
+WHERE image_path LIKE '/%' OR duplicate_of LIKE '/%';
+
+UPDATE entity_image_variant
+SET image_path = pg_temp.storage_key(image_path, service, entity_id),
+    variant_path = pg_temp.storage_key(variant_path, service, entity_id)
+WHERE image_path LIKE '/%' OR variant_path LIKE '/%';
+
+UPDATE entity_image_palette
+SET image_path = pg_temp.storage_key(image_path, service, entity_id)
+WHERE image_path LIKE '/%';
+
+-- в storage_blob только файлы режима cas
+UPDATE storage_blob
+SET path = pg_temp.storage_key(path, NULL, NULL)
+WHERE path LIKE '/%';
+
+UPDATE storage_blob_ref
+SET path = pg_temp.storage_key(path, service, entity_id)
+WHERE path LIKE '/%';
+
+ALTER TABLE entity_image_variant ADD FOREIGN KEY (service, entity_id, image_path)
+    REFERENCES entity_image_list(service, entity_id, image_path)
+    ON DELETE CASCADE;
+ALTER TABLE entity_image_palette ADD FOREIGN KEY (service, entity_id, image_path)
+    REFERENCES entity_image_list(service, entity_id, image_path)
+    ON DELETE CASCADE;
+ALTER TABLE storage_blob_ref ADD FOREIGN KEY (path)
+    REFERENCES storage_blob(path)
+    ON DELETE CASCADE;
+
+DROP FUNCTION pg_temp.storage_key;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- ключи не переводятся обратно в абсолютные пути: корень тома миграции неизвестен, а прежняя
+-- версия сервиса ключи прочитать не может. Откат падает, чтобы не оставить такие строки молча
+DO $$
+BEGIN
+    RAISE EXCEPTION 'storage keys cannot be converted back to absolute paths, restore the database from a backup instead';
+END $$;
+-- +goose StatementEnd
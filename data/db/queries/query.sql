@@ -7,8 +7,29 @@ DELETE FROM entity_state
 WHERE service = $1 AND entity_id = $2;
 
 -- name: AddImage :exec
-INSERT INTO entity_image_list(service, entity_id, image_path, is_cover)
-VALUES ($1, $2, $3, $4);
+INSERT INTO entity_image_list(service, entity_id, image_path, is_cover, width, height, orientation, mime_type, quality, size_bytes, phash, duplicate_of, blurhash, dominant_color, original_width, original_height, color_conversion)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17);
+
+-- name: AddImageVariant :exec
+INSERT INTO entity_image_variant(service, entity_id, image_path, name, variant_path, width, height, mime_type, quality, size_bytes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+-- name: AddImagePaletteColor :exec
+INSERT INTO entity_image_palette(service, entity_id, image_path, position, color, name, share)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: FindEntitiesByColor :many
+SELECT entity_id
+FROM (
+    SELECT entity_id, image_path, SUM(share) AS share
+    FROM entity_image_palette
+    WHERE service = sqlc.arg(service) AND name = sqlc.arg(name) AND entity_id > sqlc.arg(after)
+    GROUP BY entity_id, image_path
+) AS images
+WHERE share >= sqlc.arg(min_share)::real
+GROUP BY entity_id
+ORDER BY entity_id
+LIMIT sqlc.arg(max_results);
 
 -- name: IncrementImageCount :exec
 UPDATE entity_state
@@ -17,7 +38,7 @@ WHERE service = $1 AND entity_id = $2;
 
 -- name: DeleteImage :exec
 DELETE FROM entity_image_list
-WHERE image_path = $1;
+WHERE service = $1 AND entity_id = $2 AND image_path = $3;
 
 -- name: DecrementImageCount :exec
 UPDATE entity_state
@@ -42,4 +63,56 @@ WHERE service = $1 AND entity_id = $2;
 -- name: GetCoverImage :one
 SELECT *
 FROM entity_image_list
-WHERE service = $1 AND entity_id = $2 AND is_cover = true;
\ No newline at end of file
+WHERE service = $1 AND entity_id = $2 AND is_cover = true;
+
+-- name: GetImageHashes :many
+SELECT image_path, phash
+FROM entity_image_list
+WHERE service = $1 AND entity_id = $2 AND phash IS NOT NULL;
+
+-- name: GetImageVariants :many
+SELECT *
+FROM entity_image_variant
+WHERE service = $1 AND entity_id = $2 AND image_path = $3;
+
+-- name: GetEntityVariants :many
+SELECT *
+FROM entity_image_variant
+WHERE service = $1 AND entity_id = $2;
+
+-- name: AddBlob :exec
+INSERT INTO storage_blob(path, ref_count)
+VALUES ($1, $2)
+ON CONFLICT (path) DO UPDATE
+SET ref_count = storage_blob.ref_count + EXCLUDED.ref_count;
+
+-- name: AddBlobRef :exec
+INSERT INTO storage_blob_ref(service, entity_id, path, ref_count)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (service, entity_id, path) DO UPDATE
+SET ref_count = storage_blob_ref.ref_count + EXCLUDED.ref_count;
+
+-- name: ReleaseBlob :one
+UPDATE storage_blob
+SET ref_count = ref_count - $2
+WHERE path = $1
+RETURNING ref_count;
+
+-- name: ReleaseBlobRef :one
+UPDATE storage_blob_ref
+SET ref_count = ref_count - $4
+WHERE service = $1 AND entity_id = $2 AND path = $3 AND ref_count >= $4
+RETURNING ref_count;
+
+-- name: DeleteEmptyBlob :exec
+DELETE FROM storage_blob
+WHERE path = $1 AND ref_count = 0;
+
+-- name: DeleteEmptyBlobRef :exec
+DELETE FROM storage_blob_ref
+WHERE service = $1 AND entity_id = $2 AND path = $3 AND ref_count = 0;
+
+-- name: GetEntityBlobRefs :many
+SELECT *
+FROM storage_blob_ref
+WHERE service = sqlc.arg(service) AND (entity_id = sqlc.arg(entity_id) OR starts_with(entity_id, sqlc.arg(entity_id) || '/'));
\ No newline at end of file
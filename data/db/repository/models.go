@@ -4,11 +4,51 @@
 
 package repository
 
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
 type EntityImageList struct {
+	Service         string
+	EntityID        string
+	ImagePath       string
+	IsCover         bool
+	Width           int32
+	Height          int32
+	Orientation     int16
+	MimeType        string
+	Quality         int16
+	SizeBytes       int64
+	Phash           pgtype.Int8
+	DuplicateOf     string
+	Blurhash        string
+	DominantColor   string
+	OriginalWidth   int32
+	OriginalHeight  int32
+	ColorConversion string
+}
+
+type EntityImagePalette struct {
 	Service   string
 	EntityID  string
 	ImagePath string
-	IsCover   bool
+	Position  int16
+	Color     string
+	Name      string
+	Share     float32
+}
+
+type EntityImageVariant struct {
+	Service     string
+	EntityID    string
+	ImagePath   string
+	Name        string
+	VariantPath string
+	Width       int32
+	Height      int32
+	MimeType    string
+	Quality     int16
+	SizeBytes   int64
 }
 
 type EntityState struct {
@@ -20,10 +60,23 @@ type EntityState struct {
 }
 
 type ProductImageList struct {
-	Service   string
-	EntityID  string
-	ImagePath string
-	IsCover   bool
+	Service         string
+	EntityID        string
+	ImagePath       string
+	IsCover         bool
+	Width           int32
+	Height          int32
+	Orientation     int16
+	MimeType        string
+	Quality         int16
+	SizeBytes       int64
+	Phash           pgtype.Int8
+	DuplicateOf     string
+	Blurhash        string
+	DominantColor   string
+	OriginalWidth   int32
+	OriginalHeight  int32
+	ColorConversion string
 }
 
 type ProductState struct {
@@ -34,11 +87,36 @@ type ProductState struct {
 	MaxCount   int32
 }
 
+type StorageBlob struct {
+	Path     string
+	RefCount int32
+}
+
+type StorageBlobRef struct {
+	Service  string
+	EntityID string
+	Path     string
+	RefCount int32
+}
+
 type UserImageList struct {
-	Service   string
-	EntityID  string
-	ImagePath string
-	IsCover   bool
+	Service         string
+	EntityID        string
+	ImagePath       string
+	IsCover         bool
+	Width           int32
+	Height          int32
+	Orientation     int16
+	MimeType        string
+	Quality         int16
+	SizeBytes       int64
+	Phash           pgtype.Int8
+	DuplicateOf     string
+	Blurhash        string
+	DominantColor   string
+	OriginalWidth   int32
+	OriginalHeight  int32
+	ColorConversion string
 }
 
 type UserState struct {
@@ -7,18 +7,74 @@ package repository
 
 import (
 	"context"
+
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const addImage = `-- name: AddImage :exec
-INSERT INTO entity_image_list(service, entity_id, image_path, is_cover)
+const addBlob = `-- name: AddBlob :exec
+INSERT INTO storage_blob(path, ref_count)
+VALUES ($1, $2)
+ON CONFLICT (path) DO UPDATE
+SET ref_count = storage_blob.ref_count + EXCLUDED.ref_count
+`
+
+type AddBlobParams struct {
+	Path     string
+	RefCount int32
+}
+
+func (q *Queries) AddBlob(ctx context.Context, arg AddBlobParams) error {
+	_, err := q.db.Exec(ctx, addBlob, arg.Path, arg.RefCount)
+	return err
+}
+
+const addBlobRef = `-- name: AddBlobRef :exec
+INSERT INTO storage_blob_ref(service, entity_id, path, ref_count)
 VALUES ($1, $2, $3, $4)
+ON CONFLICT (service, entity_id, path) DO UPDATE
+SET ref_count = storage_blob_ref.ref_count + EXCLUDED.ref_count
+`
+
+type AddBlobRefParams struct {
+	Service  string
+	EntityID string
+	Path     string
+	RefCount int32
+}
+
+func (q *Queries) AddBlobRef(ctx context.Context, arg AddBlobRefParams) error {
+	_, err := q.db.Exec(ctx, addBlobRef,
+		arg.Service,
+		arg.EntityID,
+		arg.Path,
+		arg.RefCount,
+	)
+	return err
+}
+
+const addImage = `-- name: AddImage :exec
+INSERT INTO entity_image_list(service, entity_id, image_path, is_cover, width, height, orientation, mime_type, quality, size_bytes, phash, duplicate_of, blurhash, dominant_color, original_width, original_height, color_conversion)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
 `
 
 type AddImageParams struct {
-	Service   string
-	EntityID  string
-	ImagePath string
-	IsCover   bool
+	Service         string
+	EntityID        string
+	ImagePath       string
+	IsCover         bool
+	Width           int32
+	Height          int32
+	Orientation     int16
+	MimeType        string
+	Quality         int16
+	SizeBytes       int64
+	Phash           pgtype.Int8
+	DuplicateOf     string
+	Blurhash        string
+	DominantColor   string
+	OriginalWidth   int32
+	OriginalHeight  int32
+	ColorConversion string
 }
 
 func (q *Queries) AddImage(ctx context.Context, arg AddImageParams) error {
@@ -27,6 +83,81 @@ func (q *Queries) AddImage(ctx context.Context, arg AddImageParams) error {
 		arg.EntityID,
 		arg.ImagePath,
 		arg.IsCover,
+		arg.Width,
+		arg.Height,
+		arg.Orientation,
+		arg.MimeType,
+		arg.Quality,
+		arg.SizeBytes,
+		arg.Phash,
+		arg.DuplicateOf,
+		arg.Blurhash,
+		arg.DominantColor,
+		arg.OriginalWidth,
+		arg.OriginalHeight,
+		arg.ColorConversion,
+	)
+	return err
+}
+
+const addImagePaletteColor = `-- name: AddImagePaletteColor :exec
+INSERT INTO entity_image_palette(service, entity_id, image_path, position, color, name, share)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type AddImagePaletteColorParams struct {
+	Service   string
+	EntityID  string
+	ImagePath string
+	Position  int16
+	Color     string
+	Name      string
+	Share     float32
+}
+
+func (q *Queries) AddImagePaletteColor(ctx context.Context, arg AddImagePaletteColorParams) error {
+	_, err := q.db.Exec(ctx, addImagePaletteColor,
+		arg.Service,
+		arg.EntityID,
+		arg.ImagePath,
+		arg.Position,
+		arg.Color,
+		arg.Name,
+		arg.Share,
+	)
+	return err
+}
+
+const addImageVariant = `-- name: AddImageVariant :exec
+INSERT INTO entity_image_variant(service, entity_id, image_path, name, variant_path, width, height, mime_type, quality, size_bytes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+`
+
+type AddImageVariantParams struct {
+	Service     string
+	EntityID    string
+	ImagePath   string
+	Name        string
+	VariantPath string
+	Width       int32
+	Height      int32
+	MimeType    string
+	Quality     int16
+	SizeBytes   int64
+}
+
+func (q *Queries) AddImageVariant(ctx context.Context, arg AddImageVariantParams) error {
+	_, err := q.db.Exec(ctx, addImageVariant,
+		arg.Service,
+		arg.EntityID,
+		arg.ImagePath,
+		arg.Name,
+		arg.VariantPath,
+		arg.Width,
+		arg.Height,
+		arg.MimeType,
+		arg.Quality,
+		arg.SizeBytes,
 	)
 	return err
 }
@@ -69,6 +200,32 @@ func (q *Queries) DecrementImageCount(ctx context.Context, arg DecrementImageCou
 	return err
 }
 
+const deleteEmptyBlob = `-- name: DeleteEmptyBlob :exec
+DELETE FROM storage_blob
+WHERE path = $1 AND ref_count = 0
+`
+
+func (q *Queries) DeleteEmptyBlob(ctx context.Context, path string) error {
+	_, err := q.db.Exec(ctx, deleteEmptyBlob, path)
+	return err
+}
+
+const deleteEmptyBlobRef = `-- name: DeleteEmptyBlobRef :exec
+DELETE FROM storage_blob_ref
+WHERE service = $1 AND entity_id = $2 AND path = $3 AND ref_count = 0
+`
+
+type DeleteEmptyBlobRefParams struct {
+	Service  string
+	EntityID string
+	Path     string
+}
+
+func (q *Queries) DeleteEmptyBlobRef(ctx context.Context, arg DeleteEmptyBlobRefParams) error {
+	_, err := q.db.Exec(ctx, deleteEmptyBlobRef, arg.Service, arg.EntityID, arg.Path)
+	return err
+}
+
 const deleteEntity = `-- name: DeleteEntity :exec
 DELETE FROM entity_state
 WHERE service = $1 AND entity_id = $2
@@ -86,16 +243,70 @@ func (q *Queries) DeleteEntity(ctx context.Context, arg DeleteEntityParams) erro
 
 const deleteImage = `-- name: DeleteImage :exec
 DELETE FROM entity_image_list
-WHERE image_path = $1
+WHERE service = $1 AND entity_id = $2 AND image_path = $3
 `
 
-func (q *Queries) DeleteImage(ctx context.Context, imagePath string) error {
-	_, err := q.db.Exec(ctx, deleteImage, imagePath)
+type DeleteImageParams struct {
+	Service   string
+	EntityID  string
+	ImagePath string
+}
+
+func (q *Queries) DeleteImage(ctx context.Context, arg DeleteImageParams) error {
+	_, err := q.db.Exec(ctx, deleteImage, arg.Service, arg.EntityID, arg.ImagePath)
 	return err
 }
 
+const findEntitiesByColor = `-- name: FindEntitiesByColor :many
+SELECT entity_id
+FROM (
+    SELECT entity_id, image_path, SUM(share) AS share
+    FROM entity_image_palette
+    WHERE service = $1 AND name = $2 AND entity_id > $3
+    GROUP BY entity_id, image_path
+) AS images
+WHERE share >= $4::real
+GROUP BY entity_id
+ORDER BY entity_id
+LIMIT $5
+`
+
+type FindEntitiesByColorParams struct {
+	Service    string
+	Name       string
+	After      string
+	MinShare   float32
+	MaxResults int32
+}
+
+func (q *Queries) FindEntitiesByColor(ctx context.Context, arg FindEntitiesByColorParams) ([]string, error) {
+	rows, err := q.db.Query(ctx, findEntitiesByColor,
+		arg.Service,
+		arg.Name,
+		arg.After,
+		arg.MinShare,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var entity_id string
+		if err := rows.Scan(&entity_id); err != nil {
+			return nil, err
+		}
+		items = append(items, entity_id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getCoverImage = `-- name: GetCoverImage :one
-SELECT service, entity_id, image_path, is_cover
+SELECT service, entity_id, image_path, is_cover, width, height, orientation, mime_type, quality, size_bytes, phash, duplicate_of, blurhash, dominant_color, original_width, original_height, color_conversion
 FROM entity_image_list
 WHERE service = $1 AND entity_id = $2 AND is_cover = true
 `
@@ -113,10 +324,101 @@ func (q *Queries) GetCoverImage(ctx context.Context, arg GetCoverImageParams) (E
 		&i.EntityID,
 		&i.ImagePath,
 		&i.IsCover,
+		&i.Width,
+		&i.Height,
+		&i.Orientation,
+		&i.MimeType,
+		&i.Quality,
+		&i.SizeBytes,
+		&i.Phash,
+		&i.DuplicateOf,
+		&i.Blurhash,
+		&i.DominantColor,
+		&i.OriginalWidth,
+		&i.OriginalHeight,
+		&i.ColorConversion,
 	)
 	return i, err
 }
 
+const getEntityBlobRefs = `-- name: GetEntityBlobRefs :many
+SELECT service, entity_id, path, ref_count
+FROM storage_blob_ref
+WHERE service = $1 AND (entity_id = $2 OR starts_with(entity_id, $2 || '/'))
+`
+
+type GetEntityBlobRefsParams struct {
+	Service  string
+	EntityID string
+}
+
+func (q *Queries) GetEntityBlobRefs(ctx context.Context, arg GetEntityBlobRefsParams) ([]StorageBlobRef, error) {
+	rows, err := q.db.Query(ctx, getEntityBlobRefs, arg.Service, arg.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []StorageBlobRef
+	for rows.Next() {
+		var i StorageBlobRef
+		if err := rows.Scan(
+			&i.Service,
+			&i.EntityID,
+			&i.Path,
+			&i.RefCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEntityVariants = `-- name: GetEntityVariants :many
+SELECT service, entity_id, image_path, name, variant_path, width, height, mime_type, quality, size_bytes
+FROM entity_image_variant
+WHERE service = $1 AND entity_id = $2
+`
+
+type GetEntityVariantsParams struct {
+	Service  string
+	EntityID string
+}
+
+func (q *Queries) GetEntityVariants(ctx context.Context, arg GetEntityVariantsParams) ([]EntityImageVariant, error) {
+	rows, err := q.db.Query(ctx, getEntityVariants, arg.Service, arg.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []EntityImageVariant
+	for rows.Next() {
+		var i EntityImageVariant
+		if err := rows.Scan(
+			&i.Service,
+			&i.EntityID,
+			&i.ImagePath,
+			&i.Name,
+			&i.VariantPath,
+			&i.Width,
+			&i.Height,
+			&i.MimeType,
+			&i.Quality,
+			&i.SizeBytes,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getEntityState = `-- name: GetEntityState :one
 SELECT service, entity_id, image_count, status, max_count
 FROM entity_state
@@ -141,8 +443,44 @@ func (q *Queries) GetEntityState(ctx context.Context, arg GetEntityStateParams)
 	return i, err
 }
 
+const getImageHashes = `-- name: GetImageHashes :many
+SELECT image_path, phash
+FROM entity_image_list
+WHERE service = $1 AND entity_id = $2 AND phash IS NOT NULL
+`
+
+type GetImageHashesParams struct {
+	Service  string
+	EntityID string
+}
+
+type GetImageHashesRow struct {
+	ImagePath string
+	Phash     pgtype.Int8
+}
+
+func (q *Queries) GetImageHashes(ctx context.Context, arg GetImageHashesParams) ([]GetImageHashesRow, error) {
+	rows, err := q.db.Query(ctx, getImageHashes, arg.Service, arg.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetImageHashesRow
+	for rows.Next() {
+		var i GetImageHashesRow
+		if err := rows.Scan(&i.ImagePath, &i.Phash); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getImageList = `-- name: GetImageList :many
-SELECT service, entity_id, image_path, is_cover
+SELECT service, entity_id, image_path, is_cover, width, height, orientation, mime_type, quality, size_bytes, phash, duplicate_of, blurhash, dominant_color, original_width, original_height, color_conversion
 FROM entity_image_list
 WHERE service = $1 AND entity_id = $2
 `
@@ -166,6 +504,62 @@ func (q *Queries) GetImageList(ctx context.Context, arg GetImageListParams) ([]E
 			&i.EntityID,
 			&i.ImagePath,
 			&i.IsCover,
+			&i.Width,
+			&i.Height,
+			&i.Orientation,
+			&i.MimeType,
+			&i.Quality,
+			&i.SizeBytes,
+			&i.Phash,
+			&i.DuplicateOf,
+			&i.Blurhash,
+			&i.DominantColor,
+			&i.OriginalWidth,
+			&i.OriginalHeight,
+			&i.ColorConversion,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getImageVariants = `-- name: GetImageVariants :many
+SELECT service, entity_id, image_path, name, variant_path, width, height, mime_type, quality, size_bytes
+FROM entity_image_variant
+WHERE service = $1 AND entity_id = $2 AND image_path = $3
+`
+
+type GetImageVariantsParams struct {
+	Service   string
+	EntityID  string
+	ImagePath string
+}
+
+func (q *Queries) GetImageVariants(ctx context.Context, arg GetImageVariantsParams) ([]EntityImageVariant, error) {
+	rows, err := q.db.Query(ctx, getImageVariants, arg.Service, arg.EntityID, arg.ImagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []EntityImageVariant
+	for rows.Next() {
+		var i EntityImageVariant
+		if err := rows.Scan(
+			&i.Service,
+			&i.EntityID,
+			&i.ImagePath,
+			&i.Name,
+			&i.VariantPath,
+			&i.Width,
+			&i.Height,
+			&i.MimeType,
+			&i.Quality,
+			&i.SizeBytes,
 		); err != nil {
 			return nil, err
 		}
@@ -193,6 +587,51 @@ func (q *Queries) IncrementImageCount(ctx context.Context, arg IncrementImageCou
 	return err
 }
 
+const releaseBlob = `-- name: ReleaseBlob :one
+UPDATE storage_blob
+SET ref_count = ref_count - $2
+WHERE path = $1
+RETURNING ref_count
+`
+
+type ReleaseBlobParams struct {
+	Path     string
+	RefCount int32
+}
+
+func (q *Queries) ReleaseBlob(ctx context.Context, arg ReleaseBlobParams) (int32, error) {
+	row := q.db.QueryRow(ctx, releaseBlob, arg.Path, arg.RefCount)
+	var ref_count int32
+	err := row.Scan(&ref_count)
+	return ref_count, err
+}
+
+const releaseBlobRef = `-- name: ReleaseBlobRef :one
+UPDATE storage_blob_ref
+SET ref_count = ref_count - $4
+WHERE service = $1 AND entity_id = $2 AND path = $3 AND ref_count >= $4
+RETURNING ref_count
+`
+
+type ReleaseBlobRefParams struct {
+	Service  string
+	EntityID string
+	Path     string
+	RefCount int32
+}
+
+func (q *Queries) ReleaseBlobRef(ctx context.Context, arg ReleaseBlobRefParams) (int32, error) {
+	row := q.db.QueryRow(ctx, releaseBlobRef,
+		arg.Service,
+		arg.EntityID,
+		arg.Path,
+		arg.RefCount,
+	)
+	var ref_count int32
+	err := row.Scan(&ref_count)
+	return ref_count, err
+}
+
 const setStatus = `-- name: SetStatus :exec
 UPDATE entity_state
 SET status = $1
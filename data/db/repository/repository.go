@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 
+	"github.com/glekoz/online-shop_image/internal/config"
 	"github.com/glekoz/online-shop_image/internal/models"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -15,8 +17,15 @@ type Repository struct {
 	pool *pgxpool.Pool
 }
 
-func NewRepository(ctx context.Context, dsn string) (*Repository, error) {
-	pool, err := pgxpool.New(ctx, dsn)
+func NewRepository(ctx context.Context, cfg config.DB) (*Repository, error) {
+	poolCfg, err := pgxpool.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConns > 0 {
+		poolCfg.MaxConns = cfg.MaxConns
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, err
 	}
@@ -24,6 +33,16 @@ func NewRepository(ctx context.Context, dsn string) (*Repository, error) {
 	return &Repository{q: queries, pool: pool}, nil
 }
 
+// Ping используется в проверке готовности
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.pool.Ping(ctx)
+}
+
+// Close закрывает пул соединений - вызывается последним при остановке сервиса
+func (r *Repository) Close() {
+	r.pool.Close()
+}
+
 func (r *Repository) AddImage(ctx context.Context, image models.EntityImage) error {
 	tx, err := r.pool.Begin(ctx)
 	if err != nil {
@@ -31,7 +50,25 @@ func (r *Repository) AddImage(ctx context.Context, image models.EntityImage) err
 	}
 	defer tx.Rollback(ctx)
 	qtx := r.q.WithTx(tx)
-	err = qtx.AddImage(ctx, AddImageParams(image))
+	err = qtx.AddImage(ctx, AddImageParams{
+		Service:         image.Service,
+		EntityID:        image.EntityID,
+		ImagePath:       image.ImagePath,
+		IsCover:         image.IsCover,
+		Width:           int32(image.Width),
+		Height:          int32(image.Height),
+		Orientation:     int16(image.Orientation),
+		MimeType:        image.MimeType,
+		Quality:         int16(image.Quality),
+		SizeBytes:       image.Size,
+		Phash:           toPgHash(image.PHash),
+		DuplicateOf:     image.DuplicateOf,
+		Blurhash:        image.BlurHash,
+		DominantColor:   image.DominantColor,
+		OriginalWidth:   int32(image.OriginalWidth),
+		OriginalHeight:  int32(image.OriginalHeight),
+		ColorConversion: image.ColorConversion,
+	})
 	if err != nil {
 		var PgErr *pgconn.PgError
 		if errors.As(err, &PgErr) {
@@ -42,6 +79,37 @@ func (r *Repository) AddImage(ctx context.Context, image models.EntityImage) err
 		}
 		return err
 	}
+	for _, v := range image.Variants {
+		err = qtx.AddImageVariant(ctx, AddImageVariantParams{
+			Service:     image.Service,
+			EntityID:    image.EntityID,
+			ImagePath:   image.ImagePath,
+			Name:        v.Name,
+			VariantPath: v.Path,
+			Width:       int32(v.Width),
+			Height:      int32(v.Height),
+			MimeType:    v.MimeType,
+			Quality:     int16(v.Quality),
+			SizeBytes:   v.Size,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i, c := range image.Palette {
+		err = qtx.AddImagePaletteColor(ctx, AddImagePaletteColorParams{
+			Service:   image.Service,
+			EntityID:  image.EntityID,
+			ImagePath: image.ImagePath,
+			Position:  int16(i),
+			Color:     c.Color,
+			Name:      c.Name,
+			Share:     float32(c.Share),
+		})
+		if err != nil {
+			return err
+		}
+	}
 	err = qtx.IncrementImageCount(ctx, IncrementImageCountParams{Service: image.Service, EntityID: image.EntityID})
 	if err != nil {
 		return err
@@ -59,7 +127,7 @@ func (r *Repository) DeleteImage(ctx context.Context, service, entityID, imagePa
 	}
 	defer tx.Rollback(ctx)
 	qtx := r.q.WithTx(tx)
-	err = qtx.DeleteImage(ctx, imagePath)
+	err = qtx.DeleteImage(ctx, DeleteImageParams{Service: service, EntityID: entityID, ImagePath: imagePath})
 	if err != nil {
 		return err
 	}
@@ -133,8 +201,15 @@ func (r *Repository) GetCoverImage(ctx context.Context, service, entityID string
 		}
 		return models.EntityImage{}, err
 	}
-
-	return models.EntityImage(image), nil
+	dbVariants, err := r.q.GetImageVariants(ctx, GetImageVariantsParams{Service: service, EntityID: entityID, ImagePath: image.ImagePath})
+	if err != nil {
+		return models.EntityImage{}, err
+	}
+	cover := toEntityImage(image)
+	for _, v := range dbVariants {
+		cover.Variants = append(cover.Variants, toImageVariant(v))
+	}
+	return cover, nil
 }
 
 func (r *Repository) GetImageList(ctx context.Context, service, entityID string) ([]models.EntityImage, error) {
@@ -149,13 +224,144 @@ func (r *Repository) GetImageList(ctx context.Context, service, entityID string)
 		}
 		return nil, err
 	}
+	dbVariants, err := r.q.GetEntityVariants(ctx, GetEntityVariantsParams(params))
+	if err != nil {
+		return nil, err
+	}
+	variants := make(map[string][]models.ImageVariant, len(dbImages))
+	for _, v := range dbVariants {
+		variants[v.ImagePath] = append(variants[v.ImagePath], toImageVariant(v))
+	}
 	var images []models.EntityImage
 	for _, image := range dbImages {
-		images = append(images, models.EntityImage(image))
+		img := toEntityImage(image)
+		img.Variants = variants[image.ImagePath]
+		images = append(images, img)
 	}
 	return images, nil
 }
 
+func (r *Repository) GetImageVariants(ctx context.Context, service, entityID, imagePath string) ([]models.ImageVariant, error) {
+	params := GetImageVariantsParams{
+		Service:   service,
+		EntityID:  entityID,
+		ImagePath: imagePath,
+	}
+	dbVariants, err := r.q.GetImageVariants(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	var variants []models.ImageVariant
+	for _, v := range dbVariants {
+		variants = append(variants, toImageVariant(v))
+	}
+	return variants, nil
+}
+
+// GetImageHashes возвращает перцептивные хеши изображений сущности, у которых они посчитаны
+func (r *Repository) GetImageHashes(ctx context.Context, service, entityID string) ([]models.ImageHash, error) {
+	params := GetImageHashesParams{
+		Service:  service,
+		EntityID: entityID,
+	}
+	rows, err := r.q.GetImageHashes(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]models.ImageHash, 0, len(rows))
+	for _, row := range rows {
+		hashes = append(hashes, models.ImageHash{ImagePath: row.ImagePath, PHash: uint64(row.Phash.Int64)})
+	}
+	return hashes, nil
+}
+
+// FindEntitiesByColor возвращает id сущностей сервиса по возрастанию, у которых хотя бы одно изображение
+// содержит цвет name в доле не меньше minShare. after - последний id предыдущей страницы
+func (r *Repository) FindEntitiesByColor(ctx context.Context, service, name string, minShare float64, after string, limit int) ([]string, error) {
+	params := FindEntitiesByColorParams{
+		Service:    service,
+		Name:       name,
+		After:      after,
+		MinShare:   float32(minShare),
+		MaxResults: int32(limit),
+	}
+	return r.q.FindEntitiesByColor(ctx, params)
+}
+
+// AddBlobRef добавляет ссылку сущности на файл режима cas и увеличивает общий счетчик файла
+func (r *Repository) AddBlobRef(ctx context.Context, ref models.BlobRef) error {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	err = qtx.AddBlob(ctx, AddBlobParams{Path: ref.Key, RefCount: int32(ref.Count)})
+	if err != nil {
+		return err
+	}
+	err = qtx.AddBlobRef(ctx, AddBlobRefParams{Service: ref.Service, EntityID: ref.EntityID, Path: ref.Key, RefCount: int32(ref.Count)})
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ReleaseBlobRef снимает ref.Count ссылок сущности и возвращает, сколько ссылок на файл осталось всего.
+// Строки с нулевым счетчиком удаляются
+func (r *Repository) ReleaseBlobRef(ctx context.Context, ref models.BlobRef) (int, error) {
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+	qtx := r.q.WithTx(tx)
+	left, err := qtx.ReleaseBlobRef(ctx, ReleaseBlobRefParams{Service: ref.Service, EntityID: ref.EntityID, Path: ref.Key, RefCount: int32(ref.Count)})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, models.ErrNotFound
+		}
+		return 0, err
+	}
+	if left == 0 {
+		err = qtx.DeleteEmptyBlobRef(ctx, DeleteEmptyBlobRefParams{Service: ref.Service, EntityID: ref.EntityID, Path: ref.Key})
+		if err != nil {
+			return 0, err
+		}
+	}
+	total, err := qtx.ReleaseBlob(ctx, ReleaseBlobParams{Path: ref.Key, RefCount: int32(ref.Count)})
+	if err != nil {
+		return 0, err
+	}
+	if total == 0 {
+		err = qtx.DeleteEmptyBlob(ctx, ref.Key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return int(total), nil
+}
+
+// GetEntityBlobRefs возвращает ссылки сущности на файлы режима cas, в том числе временные (<entityID>/tmp)
+func (r *Repository) GetEntityBlobRefs(ctx context.Context, service, entityID string) ([]models.BlobRef, error) {
+	params := GetEntityBlobRefsParams{
+		Service:  service,
+		EntityID: entityID,
+	}
+	rows, err := r.q.GetEntityBlobRefs(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]models.BlobRef, 0, len(rows))
+	for _, row := range rows {
+		refs = append(refs, models.BlobRef{Service: row.Service, EntityID: row.EntityID, Key: row.Path, Count: int(row.RefCount)})
+	}
+	return refs, nil
+}
+
 func (r *Repository) SetStatus(ctx context.Context, service, entityID, status string) error {
 	params := SetStatusParams{
 		Status:   status,
@@ -169,6 +375,56 @@ func (r *Repository) SetStatus(ctx context.Context, service, entityID, status st
 	return nil
 }
 
+func toEntityImage(image EntityImageList) models.EntityImage {
+	return models.EntityImage{
+		Service:         image.Service,
+		EntityID:        image.EntityID,
+		ImagePath:       image.ImagePath,
+		MimeType:        image.MimeType,
+		IsCover:         image.IsCover,
+		Width:           int(image.Width),
+		Height:          int(image.Height),
+		Orientation:     int(image.Orientation),
+		Quality:         int(image.Quality),
+		Size:            image.SizeBytes,
+		PHash:           fromPgHash(image.Phash),
+		DuplicateOf:     image.DuplicateOf,
+		BlurHash:        image.Blurhash,
+		DominantColor:   image.DominantColor,
+		OriginalWidth:   int(image.OriginalWidth),
+		OriginalHeight:  int(image.OriginalHeight),
+		ColorConversion: image.ColorConversion,
+	}
+}
+
+// хеш хранится в BIGINT со знаком - биты те же, меняется только тип
+func toPgHash(hash *uint64) pgtype.Int8 {
+	if hash == nil {
+		return pgtype.Int8{}
+	}
+	return pgtype.Int8{Int64: int64(*hash), Valid: true}
+}
+
+func fromPgHash(hash pgtype.Int8) *uint64 {
+	if !hash.Valid {
+		return nil
+	}
+	h := uint64(hash.Int64)
+	return &h
+}
+
+func toImageVariant(v EntityImageVariant) models.ImageVariant {
+	return models.ImageVariant{
+		Name:     v.Name,
+		Path:     v.VariantPath,
+		MimeType: v.MimeType,
+		Width:    int(v.Width),
+		Height:   int(v.Height),
+		Quality:  int(v.Quality),
+		Size:     v.SizeBytes,
+	}
+}
+
 /*
 заменяется инкрементом изображений и фри статусом после сохранения
 func (r *Repository) SetCountAndFreeStatus(ctx context.Context, service, entityID, status string, images int) error {
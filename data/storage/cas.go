@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"image"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+const (
+	casLocks   = 64               // мьютексы на ключи файлов, файл всегда попадает на один и тот же
+	refTimeout = 10 * time.Second // на запросы к БД из Delete и DeleteAll, у которых нет своего контекста
+)
+
+// BlobStore - хранилище готовых байтов по ключу, поверх которого работает CASStorage
+type BlobStore interface {
+	PutBlob(ctx context.Context, key string, data []byte, mime string) error
+	HasBlob(ctx context.Context, key string) (bool, error)
+	RemoveBlob(key string) error
+	GetRawImage(ctx context.Context, key string) (image.Image, error)
+	Check(ctx context.Context) error
+}
+
+// RefStore учитывает ссылки сущностей на файлы
+type RefStore interface {
+	AddBlobRef(ctx context.Context, ref models.BlobRef) error
+	// ReleaseBlobRef снимает ref.Count ссылок и возвращает, сколько ссылок на файл осталось
+	// у всех сущностей. Неизвестная ссылка - models.ErrNotFound
+	ReleaseBlobRef(ctx context.Context, ref models.BlobRef) (int, error)
+	// GetEntityBlobRefs возвращает ссылки сущности вместе с ее временными файлами
+	GetEntityBlobRefs(ctx context.Context, service, entityID string) ([]models.BlobRef, error)
+}
+
+// CASStorage хранит файлы по содержимому: cas/<ab>/<cd>/<sha256><ext>, где ab и cd - первые байты хеша.
+// Одинаковые файлы разных сущностей лежат один раз, а сколько раз каждая сущность сохранила файл,
+// считается в БД: Delete и DeleteAll удаляют сам файл, только когда ссылок на него не осталось.
+// Счетчики и файл меняются под мьютексом ключа - рассчитано на один экземпляр сервиса, как и SyncController
+type CASStorage struct {
+	blobs   BlobStore
+	refs    RefStore
+	Quality int // качество JPEG при кодировании
+	locks   [casLocks]sync.Mutex
+}
+
+func NewCASStorage(blobs BlobStore, refs RefStore, quality int) *CASStorage {
+	return &CASStorage{blobs: blobs, refs: refs, Quality: quality}
+}
+
+func (c *CASStorage) Check(ctx context.Context) error {
+	return c.blobs.Check(ctx)
+}
+
+// Save кодирует изображение и сохраняет файл, если такого еще нет. imageID в пути не участвует
+func (c *CASStorage) Save(ctx context.Context, service, entityID, imageID string, img image.Image, opts models.EncodeOptions) (models.StoredFile, error) {
+	loc := "CASStorage.Save"
+	data, ft, quality, err := encodeFile(ctx, img, opts, c.Quality)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	file, err := c.store(ctx, service, entityID, data, ft)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	file.Quality = quality
+	return file, nil
+}
+
+// SaveRaw сохраняет байты загрузки как есть. Ключ - хеш содержимого, поэтому поток
+// сначала читается в память целиком
+func (c *CASStorage) SaveRaw(ctx context.Context, service, entityID, imageID, format string, r io.Reader, size int64) (models.StoredFile, error) {
+	loc := "CASStorage.SaveRaw"
+	ft, ok := fileTypes[format]
+	if !ok {
+		return models.StoredFile{}, models.NewError(loc, "format "+format, models.ErrInvalidInput)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	file, err := c.store(ctx, service, entityID, data, ft)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	return file, nil
+}
+
+// store записывает файл, если такого еще нет, и добавляет сущности ссылку на него
+func (c *CASStorage) store(ctx context.Context, service, entityID string, data []byte, ft fileType) (models.StoredFile, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	key := path.Join("cas", hash[:2], hash[2:4], hash+ft.ext)
+
+	mu := c.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+	exists, err := c.blobs.HasBlob(ctx, key)
+	if err != nil {
+		return models.StoredFile{}, err
+	}
+	if !exists {
+		if err = c.blobs.PutBlob(ctx, key, data, ft.mime); err != nil {
+			return models.StoredFile{}, err
+		}
+	}
+	err = c.refs.AddBlobRef(ctx, models.BlobRef{Service: service, EntityID: entityID, Key: key, Count: 1})
+	if err != nil {
+		if !exists {
+			c.blobs.RemoveBlob(key) // на файл никто не ссылается
+		}
+		return models.StoredFile{}, models.NewError("CASStorage.store", key, err)
+	}
+	return models.StoredFile{Key: key, MimeType: ft.mime, Size: int64(len(data)), Shared: exists}, nil
+}
+
+// Delete снимает одну ссылку сущности на файл и удаляет файл, если она была последней
+func (c *CASStorage) Delete(service, entityID, key string) error {
+	loc := "CASStorage.Delete"
+	if err := checkKey(key); err != nil {
+		return models.NewError(loc, key, err)
+	}
+	err := c.release(models.BlobRef{Service: service, EntityID: entityID, Key: key, Count: 1})
+	if err != nil {
+		return models.NewError(loc, key, err)
+	}
+	return nil
+}
+
+// DeleteAll снимает все ссылки сущности, в том числе на временные файлы
+func (c *CASStorage) DeleteAll(service, entityID string) error {
+	loc := "CASStorage.DeleteAll"
+	ctx, cancel := context.WithTimeout(context.Background(), refTimeout)
+	refs, err := c.refs.GetEntityBlobRefs(ctx, service, entityID)
+	cancel()
+	if err != nil {
+		return models.NewError(loc, service+" "+entityID, err)
+	}
+	var errs []error
+	for _, ref := range refs {
+		errs = append(errs, c.release(ref))
+	}
+	if err = errors.Join(errs...); err != nil {
+		return models.NewError(loc, service+" "+entityID, err)
+	}
+	return nil
+}
+
+func (c *CASStorage) GetRawImage(ctx context.Context, key string) (image.Image, error) {
+	return c.blobs.GetRawImage(ctx, key)
+}
+
+// release снимает ref.Count ссылок. Неизвестная ссылка - fs.ErrNotExist, как у удаления
+// несуществующего файла в обычном режиме
+func (c *CASStorage) release(ref models.BlobRef) error {
+	mu := c.lock(ref.Key)
+	mu.Lock()
+	defer mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), refTimeout)
+	defer cancel()
+	left, err := c.refs.ReleaseBlobRef(ctx, ref)
+	if errors.Is(err, models.ErrNotFound) {
+		return fs.ErrNotExist
+	}
+	if err != nil || left > 0 {
+		return err
+	}
+	err = c.blobs.RemoveBlob(ref.Key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil // файл уже удален, например вручную
+	}
+	return err
+}
+
+func (c *CASStorage) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &c.locks[h.Sum32()%casLocks]
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Ключ хранилища - путь файла через "/" относительно тома или бакета: <service>/<entityID>/<imageID>.<ext>,
+// в режиме cas - cas/<ab>/<cd>/<sha256>.<ext>. Ключ хранится в БД вместо пути и не зависит от того,
+// куда смонтирован том, а ссылки для клиентов из него строит publicurl.Builder
+
+func entityKey(service, entityID, name string) string {
+	return path.Join(service, entityID, name)
+}
+
+// checkKey отклоняет ключи, которые могли бы выйти за пределы тома: абсолютные,
+// с элементами "." и "..", пустые
+func checkKey(key string) error {
+	if key == "." || !fs.ValidPath(key) {
+		return models.NewError("storage.checkKey", key, models.ErrInvalidInput)
+	}
+	return nil
+}
+
+// checkEntityKey проверяет, что ключ лежит под <service>/<entityID>/: Delete не должен удалять
+// файлы другой сущности. Чужой ключ - models.ErrNotFound
+func checkEntityKey(service, entityID, key string) error {
+	dir := service + "/" + entityID
+	if err := checkKey(dir); err != nil {
+		return err
+	}
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(key, dir+"/") {
+		return models.NewError("storage.checkEntityKey", key, models.ErrNotFound)
+	}
+	return nil
+}
@@ -1,5 +1,224 @@
 package storage
 
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"time"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/imageproc"
+	"github.com/glekoz/online-shop_image/internal/models"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// минимальный размер части multipart загрузки в S3, кроме последней
+const minPartSize = 5 << 20
+
+// S3Storage хранит изображения в бакете S3-совместимого хранилища (AWS S3, MinIO).
+// Ключи объектов - те же ключи хранилища, что у локального Storage: <service>/<entityID>/<imageID>.<ext>
 type S3Storage struct {
-	Path string // типа "/static/image" - зависит от настроек, какой том выделен в докере (в этом сервисе) под хранение изображений
+	client   *minio.Client
+	Bucket   string
+	Quality  int    // качество JPEG при кодировании
+	PartSize uint64 // файлы больше загружаются по частям
+	Timeout  time.Duration
+	Unsigned bool // тело запроса не подписывается
+}
+
+// NewS3Storage подключается к хранилищу и создает бакет, если его еще нет, -
+// как локальный Storage создает свой каталог
+func NewS3Storage(ctx context.Context, cfg config.Storage) (*S3Storage, error) {
+	loc := "S3Storage.NewS3Storage"
+	s3 := cfg.S3
+	if s3.Endpoint == "" || s3.Bucket == "" {
+		return nil, models.NewError(loc, "endpoint and bucket are required", models.ErrInvalidInput)
+	}
+	if s3.PartSize < minPartSize {
+		return nil, models.NewError(loc, "part_size is less than 5 MiB", models.ErrInvalidInput)
+	}
+	lookup := minio.BucketLookupAuto
+	if s3.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(s3.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(s3.AccessKey, s3.SecretKey, ""),
+		Secure:       s3.UseSSL,
+		Region:       s3.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, models.NewError(loc, s3.Endpoint, err)
+	}
+	exists, err := client.BucketExists(ctx, s3.Bucket)
+	if err != nil {
+		return nil, models.NewError(loc, s3.Bucket, err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, s3.Bucket, minio.MakeBucketOptions{Region: s3.Region})
+		if err != nil {
+			return nil, models.NewError(loc, s3.Bucket, err)
+		}
+	}
+	return &S3Storage{client: client, Bucket: s3.Bucket, Quality: cfg.JPEGQuality, PartSize: uint64(s3.PartSize), Timeout: s3.Timeout,
+		Unsigned: s3.UnsignedPayload}, nil
+}
+
+// Check проверяет, что бакет доступен - используется в проверке готовности
+func (s *S3Storage) Check(ctx context.Context) error {
+	loc := "S3Storage.Check"
+	exists, err := s.client.BucketExists(ctx, s.Bucket)
+	if err != nil {
+		return models.NewError(loc, s.Bucket, err)
+	}
+	if !exists {
+		return models.NewError(loc, s.Bucket, models.ErrNotFound)
+	}
+	return nil
+}
+
+func (s *S3Storage) Save(ctx context.Context, service, entityID, imageID string, img image.Image, opts models.EncodeOptions) (models.StoredFile, error) {
+	loc := "S3Storage.Save"
+	data, ft, quality, err := encodeFile(ctx, img, opts, s.Quality)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	key := entityKey(service, entityID, imageID+ft.ext)
+	if err = s.PutBlob(ctx, key, data, ft.mime); err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	return models.StoredFile{Key: key, MimeType: ft.mime, Quality: quality, Size: int64(len(data))}, nil
+}
+
+// SaveRaw сохраняет байты загрузки как есть. При известном size файл до PartSize уходит одним
+// запросом, иначе клиент читает поток частями по PartSize и держит в памяти целую часть
+func (s *S3Storage) SaveRaw(ctx context.Context, service, entityID, imageID, format string, r io.Reader, size int64) (models.StoredFile, error) {
+	loc := "S3Storage.SaveRaw"
+	ft, ok := fileTypes[format]
+	if !ok {
+		return models.StoredFile{}, models.NewError(loc, "format "+format, models.ErrInvalidInput)
+	}
+	key := entityKey(service, entityID, imageID+ft.ext)
+	written, err := s.putObject(ctx, key, r, size, ft.mime)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	return models.StoredFile{Key: key, MimeType: ft.mime, Size: written}, nil
+}
+
+func (s *S3Storage) PutBlob(ctx context.Context, key string, data []byte, mime string) error {
+	_, err := s.putObject(ctx, key, bytes.NewReader(data), int64(len(data)), mime)
+	return err
+}
+
+// putObject загружает r под ключом key, size -1 - размер неизвестен
+func (s *S3Storage) putObject(ctx context.Context, key string, r io.Reader, size int64, mime string) (int64, error) {
+	loc := "S3Storage.putObject"
+	if err := checkKey(key); err != nil {
+		return 0, models.NewError(loc, key, err)
+	}
+	// файл больше PartSize клиент сам загружает по частям и отменяет загрузку при ошибке
+	info, err := s.client.PutObject(ctx, s.Bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:          mime,
+		PartSize:             s.PartSize,
+		DisableContentSha256: s.Unsigned,
+	})
+	if err != nil {
+		return 0, models.NewError(loc, key, err)
+	}
+	return info.Size, nil
+}
+
+func (s *S3Storage) HasBlob(ctx context.Context, key string) (bool, error) {
+	loc := "S3Storage.HasBlob"
+	_, err := s.client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+			return false, nil
+		}
+		return false, models.NewError(loc, key, err)
+	}
+	return true, nil
+}
+
+// Delete удаляет объект сущности по ключу. Ключ вне <service>/<entityID>/ - models.ErrNotFound
+func (s *S3Storage) Delete(service, entityID, key string) error {
+	if err := checkEntityKey(service, entityID, key); err != nil {
+		return models.NewError("S3Storage.Delete", key, err)
+	}
+	return s.RemoveBlob(key)
+}
+
+func (s *S3Storage) RemoveBlob(key string) error {
+	loc := "S3Storage.RemoveBlob"
+	if err := checkKey(key); err != nil {
+		return models.NewError(loc, key, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	err := s.client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return models.NewError(loc, key, err)
+	}
+	return nil
+}
+
+// DeleteAll удаляет все объекты сущности, в том числе временные, по префиксу <service>/<entityID>/
+func (s *S3Storage) DeleteAll(service, entityID string) error {
+	loc := "S3Storage.DeleteAll"
+	if err := checkKey(service + "/" + entityID); err != nil {
+		return models.NewError(loc, service+" "+entityID, err)
+	}
+	prefix := entityKey(service, entityID, "") + "/"
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	var listErr error
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for obj := range s.client.ListObjects(ctx, s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if obj.Err != nil {
+				listErr = obj.Err
+				return
+			}
+			select {
+			case objects <- obj:
+			case <-ctx.Done(): // RemoveObjects больше не читает
+				return
+			}
+		}
+	}()
+	// RemoveObjects удаляет пачками до 1000 ключей за запрос
+	var errs []error
+	for res := range s.client.RemoveObjects(ctx, s.Bucket, objects, minio.RemoveObjectsOptions{}) {
+		errs = append(errs, res.Err)
+	}
+	// канал objects уже закрыт - горутина списка завершилась
+	if err := errors.Join(append(errs, listErr)...); err != nil {
+		return models.NewError(loc, prefix, err)
+	}
+	return nil
+}
+
+// GetRawImage читает объект по ключу и декодирует его так же, как локальный Storage
+func (s *S3Storage) GetRawImage(ctx context.Context, key string) (image.Image, error) {
+	loc := "S3Storage.GetRawImage"
+	obj, err := s.client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, models.NewError(loc, key, err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, models.NewError(loc, key, err)
+	}
+	decoded, err := imageproc.Decode(ctx, data, nil, imageproc.Limits{})
+	if err != nil {
+		return nil, models.NewError(loc, key, err)
+	}
+	return decoded.Image, nil
 }
@@ -0,0 +1,249 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/models"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/minio/minio-go/v7"
+)
+
+func newTestS3(t *testing.T) *S3Storage {
+	t.Helper()
+	srv := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(srv.Close)
+	s, err := NewS3Storage(context.Background(), config.Storage{
+		JPEGQuality: 90,
+		S3: config.S3{
+			Endpoint:        strings.TrimPrefix(srv.URL, "http://"),
+			Region:          "us-east-1",
+			Bucket:          "images",
+			AccessKey:       "key",
+			SecretKey:       "secret",
+			PathStyle:       true,
+			UnsignedPayload: true,
+			PartSize:        minPartSize,
+			Timeout:         time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 24))
+	for y := range 24 {
+		for x := range 32 {
+			img.Set(x, y, color.NRGBA{uint8(x * 8), uint8(y * 10), 120, 255})
+		}
+	}
+	return img
+}
+
+func getObject(t *testing.T, s *S3Storage, key string) []byte {
+	t.Helper()
+	obj, err := s.client.GetObject(context.Background(), s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func listKeys(t *testing.T, s *S3Storage, prefix string) []string {
+	t.Helper()
+	var keys []string
+	for obj := range s.client.ListObjects(context.Background(), s.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			t.Fatal(obj.Err)
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys
+}
+
+func TestS3SaveAndDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestS3(t)
+
+	for _, format := range []string{"jpeg", "webp"} {
+		file, err := s.Save(ctx, "product", "42", "img", testImage(), models.EncodeOptions{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "product/42/img." + format; file.Key != want {
+			t.Fatalf("key %q, want %q", file.Key, want)
+		}
+		if file.MimeType != "image/"+format || file.Size != int64(len(getObject(t, s, file.Key))) {
+			t.Fatalf("%s: stored %+v", format, file)
+		}
+		img, err := s.GetRawImage(ctx, file.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Size() != image.Pt(32, 24) {
+			t.Fatalf("%s: decoded %v", format, img.Bounds())
+		}
+
+		if err = s.Delete("product", "42", file.Key); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := s.HasBlob(ctx, file.Key); err != nil || exists {
+			t.Fatalf("%s: after Delete exists=%v err=%v", format, exists, err)
+		}
+	}
+
+	if _, err := s.Save(ctx, "product", "42", "img", testImage(), models.EncodeOptions{Format: "png"}); err == nil {
+		t.Fatal("png output: want error")
+	}
+	if err := s.Delete("product", "42", "../escape"); err == nil {
+		t.Fatal("key with ..: want error")
+	}
+	// ключ другой сущности не удаляется
+	file, err := s.Save(ctx, "product", "420", "img", testImage(), models.EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Delete("product", "42", file.Key); !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("foreign key: err = %v, want models.ErrNotFound", err)
+	}
+	if exists, err := s.HasBlob(ctx, file.Key); err != nil || !exists {
+		t.Fatalf("foreign key: exists=%v err=%v", exists, err)
+	}
+}
+
+func TestS3SaveRaw(t *testing.T) {
+	ctx := context.Background()
+	s := newTestS3(t)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// размер известен и неизвестен
+	for _, size := range []int64{int64(len(data)), -1} {
+		id := fmt.Sprint("raw", size)
+		file, err := s.SaveRaw(ctx, "product", "42/tmp", id, "png", bytes.NewReader(data), size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Key != "product/42/tmp/"+id+".png" || file.MimeType != "image/png" || file.Size != int64(len(data)) {
+			t.Fatalf("stored %+v", file)
+		}
+		if !bytes.Equal(getObject(t, s, file.Key), data) {
+			t.Fatal("stored bytes differ from the upload")
+		}
+		img, err := s.GetRawImage(ctx, file.Key)
+		if err != nil || img.Bounds().Size() != image.Pt(32, 24) {
+			t.Fatalf("decoded %v, err %v", img, err)
+		}
+	}
+
+	if _, err := s.SaveRaw(ctx, "product", "42/tmp", "x", "heic", bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatal("unknown format: want error")
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	ctx := context.Background()
+	s := newTestS3(t)
+	// больше двух частей: последняя неполная
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+minPartSize/2)/16)
+
+	for _, size := range []int64{int64(len(data)), -1} {
+		id := fmt.Sprint("big", size)
+		file, err := s.SaveRaw(ctx, "product", "42/tmp", id, "jpeg", bytes.NewReader(data), size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.Size != int64(len(data)) {
+			t.Fatalf("size %d, want %d", file.Size, len(data))
+		}
+		if !bytes.Equal(getObject(t, s, file.Key), data) {
+			t.Fatal("multipart object differs from the upload")
+		}
+	}
+	if err := s.PutBlob(ctx, "product/42/blob.jpeg", data, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(getObject(t, s, "product/42/blob.jpeg"), data) {
+		t.Fatal("multipart blob differs")
+	}
+}
+
+func TestS3DeleteAll(t *testing.T) {
+	ctx := context.Background()
+	s := newTestS3(t)
+	// больше одной страницы списка объектов (1000 ключей)
+	const n = 1100
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	errs := make(chan error, n+2)
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				errs <- s.PutBlob(ctx, key, []byte(key), "image/jpeg")
+			}
+		}()
+	}
+	for i := range n {
+		if i%2 == 0 {
+			keys <- fmt.Sprintf("product/42/img%04d.jpeg", i)
+		} else {
+			keys <- fmt.Sprintf("product/42/tmp/img%04d.png", i)
+		}
+	}
+	// соседи с тем же началом ключа не должны попасть под префикс
+	keys <- "product/420/keep.jpeg"
+	keys <- "user/42/keep.jpeg"
+	close(keys)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(listKeys(t, s, "product/42/")); got != n {
+		t.Fatalf("stored %d objects, want %d", got, n)
+	}
+
+	if err := s.DeleteAll("product", "42"); err != nil {
+		t.Fatal(err)
+	}
+	if left := listKeys(t, s, "product/42/"); len(left) != 0 {
+		t.Fatalf("%d objects left, first %q", len(left), left[0])
+	}
+	for _, key := range []string{"product/420/keep.jpeg", "user/42/keep.jpeg"} {
+		if exists, err := s.HasBlob(ctx, key); err != nil || !exists {
+			t.Fatalf("%s: exists=%v err=%v", key, exists, err)
+		}
+	}
+
+	if err := s.DeleteAll("product", "../42"); err == nil {
+		t.Fatal("entity with ..: want error")
+	}
+}
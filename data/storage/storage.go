@@ -1,34 +1,88 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	"image/jpeg"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/imageproc"
 	"github.com/glekoz/online-shop_image/internal/models"
 )
 
+// префикс временных файлов writeFile, по нему SweepPartial находит недописанные
+const partialPrefix = ".partial-"
+
+// fileType - расширение файла и mime тип выходного формата
+type fileType struct{ ext, mime string }
+
+// расширение файла и mime тип для каждого формата: выходные форматы кодируются при Save,
+// остальные входные встречаются только у загрузок, сохраненных SaveRaw как есть
+var fileTypes = map[string]fileType{
+	"jpeg": {".jpeg", "image/jpeg"},
+	"webp": {".webp", "image/webp"},
+	"png":  {".png", "image/png"},
+	"gif":  {".gif", "image/gif"},
+	"bmp":  {".bmp", "image/bmp"},
+	"tiff": {".tiff", "image/tiff"},
+}
+
 type Storage struct {
-	Path string // типа "/static/image" - зависит от настроек, какой том выделен в докере (в этом сервисе) под хранение изображений
+	Path    string // типа "/static/image" - зависит от настроек, какой том выделен в докере (в этом сервисе) под хранение изображений
+	Quality int    // качество JPEG при кодировании
 }
 
-func NewStorage(p string) (Storage, error) {
+func NewStorage(cfg config.Storage) (Storage, error) {
 	loc := "Storage.NewStorage"
-	if err := os.MkdirAll(p, 0o755); err != nil {
-		return Storage{}, models.NewError(loc, p, err)
+	if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+		return Storage{}, models.NewError(loc, cfg.Path, err)
+	}
+	return Storage{Path: cfg.Path, Quality: cfg.JPEGQuality}, nil
+}
+
+// Check проверяет, что том существует и в него можно писать - используется в проверке готовности
+func (s Storage) Check(ctx context.Context) error {
+	loc := "Storage.Check"
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return models.NewError(loc, s.Path, err)
+	}
+	if !info.IsDir() {
+		return models.NewError(loc, s.Path, models.ErrFS)
+	}
+	file, err := os.CreateTemp(s.Path, ".healthcheck-*")
+	if err != nil {
+		return models.NewError(loc, s.Path, err)
 	}
-	return Storage{Path: p}, nil
+	defer os.Remove(file.Name())
+	if _, err = file.Write([]byte{0}); err != nil {
+		file.Close()
+		return models.NewError(loc, file.Name(), err)
+	}
+	if err = file.Close(); err != nil {
+		return models.NewError(loc, file.Name(), err)
+	}
+	return ctx.Err()
 }
 
 // надо что-то думать насчет аргументов
 // как будто нужны уже целые пути, а не составные части
-func (s Storage) Save(ctx context.Context, service, entityID, imageID string, img image.Image) (string, error) {
+func (s Storage) Save(ctx context.Context, service, entityID, imageID string, img image.Image, opts models.EncodeOptions) (models.StoredFile, error) {
 	loc := "Storage.Save"
 	type Result struct {
-		imagePath string
-		err       error
+		file models.StoredFile
+		err  error
 	}
 	resultChan := make(chan Result, 1)
 
@@ -36,47 +90,245 @@ func (s Storage) Save(ctx context.Context, service, entityID, imageID string, im
 		//result := Result{}
 		defer close(resultChan)
 
-		pwd := filepath.Join(s.Path, service, entityID)
-		err := os.MkdirAll(pwd, 0o755)
+		// кодируется в память: подбор качества под бюджет требует нескольких попыток
+		data, ft, quality, err := encodeFile(ctx, img, opts, s.Quality)
 		if err != nil {
-			ch <- Result{"", models.NewError(loc, pwd, err)}
+			ch <- Result{models.StoredFile{}, models.NewError(loc, imageID, err)}
 			return
 		}
 
-		imagePath := filepath.Join(pwd, imageID+".jpeg")
-		file, err := os.Create(imagePath)
+		key := entityKey(service, entityID, imageID+ft.ext)
+		err = s.PutBlob(ctx, key, data, ft.mime)
 		if err != nil {
-			ch <- Result{"", models.NewError(loc, imagePath, err)}
+			ch <- Result{models.StoredFile{}, models.NewError(loc, imageID, err)}
 			return
 		}
-		defer func() {
-			file.Close()
-			if ctx.Err() != nil || err != nil {
-				os.Remove(imagePath) // удаляем файл, если произошла ошибка
-			}
-		}()
-
-		if err = ctx.Err(); err != nil {
-			ch <- Result{"", models.NewError(loc, "context", err)}
-			return
-		}
-
-		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 95})
-		if err != nil {
-			ch <- Result{"", models.NewError(loc, imageID, err)}
-			return
-		}
-		ch <- Result{imagePath, nil} // возвращаем путь к файлу, чтобы можно было использовать в других методах
+		// возвращаем ключ файла, чтобы можно было использовать в других методах
+		ch <- Result{models.StoredFile{Key: key, MimeType: ft.mime, Quality: quality, Size: int64(len(data))}, nil}
 	}(resultChan)
 	select {
 	case <-ctx.Done():
-		return "", models.NewError(loc, "context", ctx.Err())
+		return models.StoredFile{}, models.NewError(loc, "context", ctx.Err())
 	case result := <-resultChan:
 		if result.err != nil {
-			return "", result.err
+			return models.StoredFile{}, result.err
+		}
+		return result.file, nil
+	}
+}
+
+// file - путь файла с ключом key внутри тома
+func (s Storage) file(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Path, filepath.FromSlash(key)), nil
+}
+
+// SaveRaw сохраняет байты загрузки как есть, без декодирования и кодирования. format - формат
+// загрузки из imageproc.Formats, по нему выбирается расширение. size файлу не нужен
+func (s Storage) SaveRaw(ctx context.Context, service, entityID, imageID, format string, r io.Reader, size int64) (models.StoredFile, error) {
+	loc := "Storage.SaveRaw"
+	ft, ok := fileTypes[format]
+	if !ok {
+		return models.StoredFile{}, models.NewError(loc, "format "+format, models.ErrInvalidInput)
+	}
+	key := entityKey(service, entityID, imageID+ft.ext)
+	written, err := s.writeFile(ctx, key, r)
+	if err != nil {
+		return models.StoredFile{}, models.NewError(loc, imageID, err)
+	}
+	return models.StoredFile{Key: key, MimeType: ft.mime, Size: written}, nil
+}
+
+// PutBlob записывает уже закодированный файл
+func (s Storage) PutBlob(ctx context.Context, key string, data []byte, mime string) error {
+	_, err := s.writeFile(ctx, key, bytes.NewReader(data))
+	return err
+}
+
+// writeFile переносит r в файл с ключом key, создавая недостающие папки, и возвращает размер.
+// Байты сначала пишутся во временный файл рядом и сбрасываются на диск, а на место итогового
+// он переименовывается только целиком: после падения по итоговому пути лежит либо весь файл,
+// либо ничего. Недописанные временные файлы убирает SweepPartial при старте
+func (s Storage) writeFile(ctx context.Context, key string, r io.Reader) (int64, error) {
+	loc := "Storage.writeFile"
+	path, err := s.file(key)
+	if err != nil {
+		return 0, models.NewError(loc, key, err)
+	}
+	dir := filepath.Dir(path)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return 0, models.NewError(loc, path, err)
+	}
+	file, err := os.CreateTemp(dir, partialPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return 0, models.NewError(loc, path, err)
+	}
+	tmpPath := file.Name()
+	var renamed bool
+	defer func() {
+		file.Close()
+		if !renamed {
+			os.Remove(tmpPath) // удаляем файл, если произошла ошибка
+		}
+	}()
+
+	if err = ctx.Err(); err != nil {
+		return 0, models.NewError(loc, "context", err)
+	}
+
+	size, err := io.Copy(file, r)
+	if err != nil {
+		return 0, models.NewError(loc, tmpPath, err)
+	}
+	// CreateTemp создает файл только для владельца, а раздавать его может и другой процесс
+	if err = file.Chmod(0o644); err != nil {
+		return 0, models.NewError(loc, tmpPath, err)
+	}
+	if err = file.Sync(); err != nil {
+		return 0, models.NewError(loc, tmpPath, err)
+	}
+	if err = file.Close(); err != nil {
+		return 0, models.NewError(loc, tmpPath, err)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return 0, models.NewError(loc, "context", err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return 0, models.NewError(loc, path, err)
+	}
+	renamed = true
+	// без этого после падения системы переименование может не сохраниться
+	if err = syncDir(dir); err != nil {
+		return 0, models.NewError(loc, dir, err)
+	}
+	return size, nil
+}
+
+// SweepPartial удаляет временные файлы, которые writeFile не успел дописать или переименовать
+// до падения процесса, и возвращает их количество. Вызывается при старте, пока записи не идут
+func (s Storage) SweepPartial() (int, error) {
+	loc := "Storage.SweepPartial"
+	var removed int
+	err := filepath.WalkDir(s.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), partialPrefix) {
+			return nil
 		}
-		return result.imagePath, nil
+		if err = os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, models.NewError(loc, s.Path, err)
 	}
+	return removed, nil
+}
+
+// syncDir сбрасывает на диск записи каталога
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s Storage) HasBlob(ctx context.Context, key string) (bool, error) {
+	loc := "Storage.HasBlob"
+	path, err := s.file(key)
+	if err != nil {
+		return false, models.NewError(loc, key, err)
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, models.NewError(loc, path, err)
+	}
+	return true, nil
+}
+
+// encodeFile подставляет в opts настройки хранилища по умолчанию и кодирует изображение
+func encodeFile(ctx context.Context, img image.Image, opts models.EncodeOptions, quality int) ([]byte, fileType, int, error) {
+	if opts.Format == "" {
+		opts.Format = "jpeg"
+	}
+	if !slices.Contains(imageproc.OutputFormats, opts.Format) {
+		return nil, fileType{}, 0, fmt.Errorf("format %s: %w", opts.Format, models.ErrInvalidInput)
+	}
+	ft := fileTypes[opts.Format]
+	if opts.Quality == 0 {
+		opts.Quality = quality
+	}
+	data, quality, err := encode(ctx, img, opts)
+	if err != nil {
+		return nil, fileType{}, 0, err
+	}
+	return data, ft, quality, nil
+}
+
+// encode кодирует изображение и возвращает байты и итоговое качество (0 - без потерь).
+// Если для jpeg задан MaxBytes, двоичным поиском ищется наибольшее качество
+// не выше opts.Quality, при котором файл укладывается в бюджет, но не ниже MinQuality:
+// если бюджет недостижим, файл сохраняется с MinQuality
+func encode(ctx context.Context, img image.Image, opts models.EncodeOptions) ([]byte, int, error) {
+	if opts.Format == "webp" {
+		// чистый Go без cgo, кодирует только без потерь (VP8L)
+		var buf bytes.Buffer
+		err := nativewebp.Encode(&buf, img, nil)
+		return buf.Bytes(), 0, err
+	}
+
+	// у JPEG нет альфа-канала - прозрачные области PNG, GIF и WebP становятся белыми, а не черными
+	img = imageproc.Flatten(img, color.White)
+	encodeJPEG := func(quality int) ([]byte, error) {
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		return buf.Bytes(), err
+	}
+
+	data, err := encodeJPEG(opts.Quality)
+	if err != nil || opts.MaxBytes <= 0 || len(data) <= opts.MaxBytes {
+		return data, opts.Quality, err
+	}
+	floor := max(opts.MinQuality, 1)
+	if floor >= opts.Quality {
+		return data, opts.Quality, nil
+	}
+	var best []byte
+	bestQuality := 0
+	lo, hi := floor, opts.Quality-1
+	for lo <= hi {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		q := (lo + hi) / 2
+		d, err := encodeJPEG(q)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(d) <= opts.MaxBytes {
+			best, bestQuality = d, q
+			lo = q + 1
+		} else {
+			hi = q - 1
+		}
+	}
+	if best == nil {
+		data, err = encodeJPEG(floor)
+		return data, floor, err
+	}
+	return best, bestQuality, nil
 }
 
 /*
@@ -90,12 +342,21 @@ defer func() {
 		}()
 */
 
-func (s Storage) Delete(path string) error {
-	loc := "Storage.Delete"
-	if path == "" {
-		return models.NewError(loc, "path == \"\"", models.ErrInvalidInput)
+// Delete удаляет файл сущности по ключу. Ключ вне <service>/<entityID>/ - models.ErrNotFound
+func (s Storage) Delete(service, entityID, key string) error {
+	if err := checkEntityKey(service, entityID, key); err != nil {
+		return models.NewError("Storage.Delete", key, err)
 	}
-	err := os.Remove(path)
+	return s.RemoveBlob(key)
+}
+
+func (s Storage) RemoveBlob(key string) error {
+	loc := "Storage.RemoveBlob"
+	path, err := s.file(key)
+	if err != nil {
+		return models.NewError(loc, key, err)
+	}
+	err = os.Remove(path)
 	if err != nil {
 		return models.NewError(loc, path, err)
 	}
@@ -104,26 +365,34 @@ func (s Storage) Delete(path string) error {
 
 func (s Storage) DeleteAll(service, entityID string) error {
 	loc := "Storage.DeleteAll"
-	path := filepath.Join(s.Path, service, entityID)
-	err := os.RemoveAll(path)
+	path, err := s.file(service + "/" + entityID)
+	if err != nil {
+		return models.NewError(loc, service+" "+entityID, err)
+	}
+	err = os.RemoveAll(path)
 	if err != nil {
 		return models.NewError(loc, path, err)
 	}
 	return nil
 }
 
-func (s Storage) GetRawImage(imagePath string) (image.Image, error) {
+// GetRawImage читает изображение любого поддерживаемого формата с учетом EXIF Orientation, без ограничений по размерам
+func (s Storage) GetRawImage(ctx context.Context, key string) (image.Image, error) {
 	loc := "Storage.GetRawImage"
-	file, err := os.Open(imagePath)
+	path, err := s.file(key)
+	if err != nil {
+		return nil, models.NewError(loc, key, err)
+	}
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, models.NewError(loc, imagePath, err)
+		return nil, models.NewError(loc, key, err)
 	}
-	defer file.Close()
-	img, _, err := image.Decode(file)
+	// формат и размеры проверены при загрузке
+	decoded, err := imageproc.Decode(ctx, data, nil, imageproc.Limits{})
 	if err != nil {
-		return nil, models.NewError(loc, imagePath, err)
+		return nil, models.NewError(loc, key, err)
 	}
-	return img, nil
+	return decoded.Image, nil
 }
 
 /*
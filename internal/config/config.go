@@ -0,0 +1,243 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+	"github.com/go-playground/validator/v10"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix - переменные окружения переопределяют значения из файла:
+// ключ amt.dsn читается из IMAGE_AMT_DSN, fileserver.port - из IMAGE_FILESERVER_PORT и т.д.
+const EnvPrefix = "IMAGE"
+
+type Config struct {
+	GRPC       GRPC       `mapstructure:"grpc"`
+	FileServer FileServer `mapstructure:"fileserver"`
+	Storage    Storage    `mapstructure:"storage"`
+	DB         DB         `mapstructure:"db"`
+	AMT        AMT        `mapstructure:"amt"`
+	Shutdown   Shutdown   `mapstructure:"shutdown"`
+	Health     Health     `mapstructure:"health"`
+	// настройки обработки по сервисам (product, user), ключ - имя сервиса
+	Services map[string]Service `mapstructure:"services" validate:"dive"`
+}
+
+type GRPC struct {
+	Addr           string `mapstructure:"addr" validate:"required,hostname_port"`
+	MaxImageSize   int    `mapstructure:"max_image_size" validate:"gt=0"`   // ограничение на сжатое изображение в UploadImage
+	MaxMessageSize int    `mapstructure:"max_message_size" validate:"gt=0"` // ограничение на одно сообщение (чанк) стрима
+}
+
+type FileServer struct {
+	Port         int           `mapstructure:"port" validate:"gt=0,lte=65535"`
+	Path         string        `mapstructure:"path" validate:"required"` // раздается под /static/
+	ReadTimeout  time.Duration `mapstructure:"read_timeout" validate:"gt=0"`
+	WriteTimeout time.Duration `mapstructure:"write_timeout" validate:"gt=0"`
+	IdleTimeout  time.Duration `mapstructure:"idle_timeout" validate:"gt=0"`
+	// база ссылок на файлы в ответах клиентам: CDN или внешний адрес файлового сервера вместе
+	// с /static, пусто - относительные ссылки /static/<key>. При backend s3 обязательна
+	PublicURL string `mapstructure:"public_url"`
+}
+
+type Storage struct {
+	Backend     string `mapstructure:"backend" validate:"oneof=local s3"`  // где хранятся файлы
+	Layout      string `mapstructure:"layout" validate:"oneof=entity cas"` // entity - по папкам сущностей, cas - по sha256 содержимого
+	Path        string `mapstructure:"path" validate:"required"`           // том, выделенный под изображения (backend local)
+	JPEGQuality int    `mapstructure:"jpeg_quality" validate:"gte=1,lte=100"`
+	S3          S3     `mapstructure:"s3"`
+}
+
+// S3 - S3-совместимое хранилище (AWS S3, MinIO), обязательные поля проверяются при backend s3
+type S3 struct {
+	Endpoint  string `mapstructure:"endpoint"` // host:port без схемы
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	UseSSL    bool   `mapstructure:"use_ssl"`
+	PathStyle bool   `mapstructure:"path_style"` // endpoint/bucket/key вместо bucket.endpoint/key - для MinIO
+	// не подписывать тело запроса (UNSIGNED-PAYLOAD), контрольные суммы частей все равно передаются.
+	// Без TLS клиент иначе шлет части в aws-chunked, который понимают не все заменители S3
+	UnsignedPayload bool `mapstructure:"unsigned_payload"`
+	// файлы больше загружаются по частям такого размера, не меньше 5 МБ - минимума S3
+	PartSize int64 `mapstructure:"part_size" validate:"gte=0"`
+	// на Delete и DeleteAll, у которых нет своего контекста
+	Timeout time.Duration `mapstructure:"timeout" validate:"gte=0"`
+}
+
+type DB struct {
+	DSN      string `mapstructure:"dsn" validate:"required"`
+	MaxConns int32  `mapstructure:"max_conns" validate:"gte=0"` // 0 - значение по умолчанию pgxpool
+}
+
+type AMT struct {
+	DSN      string        `mapstructure:"dsn" validate:"required"`
+	Queue    string        `mapstructure:"queue" validate:"required"`
+	Prefetch int           `mapstructure:"prefetch" validate:"gt=0"`
+	Workers  int           `mapstructure:"workers" validate:"gt=0"`
+	Timeout  time.Duration `mapstructure:"timeout" validate:"gt=0"` // время на обработку одного сообщения
+}
+
+type Shutdown struct {
+	Timeout time.Duration `mapstructure:"timeout" validate:"gt=0"` // общий дедлайн на остановку всех подсистем
+}
+
+type Health struct {
+	CheckTimeout time.Duration `mapstructure:"check_timeout" validate:"gt=0"` // на все проверки готовности вместе
+	Interval     time.Duration `mapstructure:"interval" validate:"gt=0"`      // как часто обновлять статус grpc.health.v1
+}
+
+type Service struct {
+	Formats  []string  `mapstructure:"formats" validate:"dive,oneof=jpeg png gif webp bmp tiff"` // входные форматы, пусто - jpeg и png
+	Limits   Limits    `mapstructure:"limits"`                                                   // проверяются в UploadImage до декодирования
+	Pipeline []Step    `mapstructure:"pipeline" validate:"dive"`                                 // шаги обработки в ProcessedSave по порядку
+	Variants []Variant `mapstructure:"variants" validate:"dive"`                                 // производные размеры, строятся из результата конвейера
+	// похожие изображения одной сущности, определяются по перцептивному хешу при загрузке
+	Duplicates Duplicates `mapstructure:"duplicates"`
+	// размытые, темные и почти однотонные загрузки, проверяются при загрузке
+	QualityCheck QualityCheck `mapstructure:"quality_check"`
+}
+
+// Duplicates - что делать с почти одинаковыми изображениями одной сущности
+type Duplicates struct {
+	Policy      string `mapstructure:"policy" validate:"omitempty,oneof=off flag reject"` // по умолчанию off
+	MaxDistance int    `mapstructure:"max_distance" validate:"gte=0,lte=64"`              // бит различия хешей, 0 - значение по умолчанию
+}
+
+// QualityCheck - пороги метрик качества загрузки: не задан - значение по умолчанию, 0 - порог не проверяется
+type QualityCheck struct {
+	Policy        string   `mapstructure:"policy" validate:"omitempty,oneof=off warn reject"` // по умолчанию off
+	MinSharpness  *float64 `mapstructure:"min_sharpness" validate:"omitnil,gte=0"`            // дисперсия лапласиана
+	MinBrightness *float64 `mapstructure:"min_brightness" validate:"omitnil,gte=0,lte=255"`   // средняя яркость
+	MaxUniformity *float64 `mapstructure:"max_uniformity" validate:"omitnil,gte=0,lte=1"`     // доля пикселей цвета фона
+}
+
+// Limits - допустимые размеры загрузки (после поворота по EXIF), 0 - значение по умолчанию
+type Limits struct {
+	MaxWidth      int     `mapstructure:"max_width" validate:"gte=0"`
+	MaxHeight     int     `mapstructure:"max_height" validate:"gte=0"`
+	MaxMegapixels float64 `mapstructure:"max_megapixels" validate:"gte=0"`
+	MinWidth      int     `mapstructure:"min_width" validate:"gte=0"`
+	MinHeight     int     `mapstructure:"min_height" validate:"gte=0"`
+}
+
+// Variant - именованный производный размер: миниатюра для списка, карточка, зум
+type Variant struct {
+	Name    string `mapstructure:"name" validate:"required,alphanum,lowercase,max=50"`
+	Width   int    `mapstructure:"width" validate:"gte=0"`
+	Height  int    `mapstructure:"height" validate:"gte=0"`
+	Fit     string `mapstructure:"fit" validate:"omitempty,oneof=inside cover"` // inside - вписать, cover - заполнить с обрезкой
+	Quality int    `mapstructure:"quality" validate:"gte=0,lte=100"`
+	// бюджет на размер файла варианта, 0 - как у encode
+	MaxBytes   int `mapstructure:"max_bytes" validate:"gte=0"`
+	MinQuality int `mapstructure:"min_quality" validate:"gte=0,lte=100"`
+}
+
+// Step - один шаг конвейера, какие поля нужны - зависит от Type
+type Step struct {
+	Type     string   `mapstructure:"type" validate:"required,oneof=resize crop pad grayscale sharpen encode placeholder palette watermark"`
+	Width    int      `mapstructure:"width" validate:"gte=0"`                      // resize
+	Height   int      `mapstructure:"height" validate:"gte=0"`                     // resize
+	Aspect   string   `mapstructure:"aspect"`                                      // crop, pad: "1:1", "3:4"
+	Color    string   `mapstructure:"color"`                                       // pad: "#ffffff" или "edge" - цвет краев
+	Amount   float64  `mapstructure:"amount" validate:"gte=0"`                     // sharpen
+	Format   string   `mapstructure:"format" validate:"omitempty,oneof=jpeg webp"` // encode, по умолчанию jpeg
+	Quality  int      `mapstructure:"quality" validate:"gte=0,lte=100"`            // encode, только jpeg, 0 - storage.jpeg_quality
+	Fallback []string `mapstructure:"fallback" validate:"dive,oneof=jpeg webp"`    // encode: запасные копии в других форматах
+	// encode: бюджет на размер jpeg, качество подбирается между min_quality и quality
+	MaxBytes   int `mapstructure:"max_bytes" validate:"gte=0"`
+	MinQuality int `mapstructure:"min_quality" validate:"gte=0,lte=100"`
+	// placeholder: число компонент BlurHash, 0 - 4 по горизонтали и 3 по вертикали
+	ComponentsX int `mapstructure:"x_components" validate:"gte=0,lte=9"`
+	ComponentsY int `mapstructure:"y_components" validate:"gte=0,lte=9"`
+	Colors      int `mapstructure:"colors" validate:"gte=0,lte=16"` // palette: сколько основных цветов, 0 - 5
+	// watermark: PNG с альфа-каналом, угол или центр, ширина знака и отступ в долях кадра, непрозрачность
+	Image    string  `mapstructure:"image"`
+	Position string  `mapstructure:"position" validate:"omitempty,oneof=top-left top-right bottom-left bottom-right center"`
+	Scale    float64 `mapstructure:"scale" validate:"gte=0,lte=1"`
+	Opacity  float64 `mapstructure:"opacity" validate:"gte=0,lte=1"`
+	Margin   float64 `mapstructure:"margin" validate:"gte=0,lte=0.5"`
+}
+
+// Load читает конфигурацию из файла (если file != "") и переменных окружения,
+// после чего проверяет её валидатором
+func Load(file string) (Config, error) {
+	loc := "config.Load"
+	v := viper.New()
+	setDefaults(v)
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return Config{}, models.NewError(loc, file, err)
+		}
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return Config{}, models.NewError(loc, file, err)
+	}
+	if cfg.FileServer.Path == "" {
+		cfg.FileServer.Path = cfg.Storage.Path
+	}
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(cfg); err != nil {
+		return Config{}, models.NewError(loc, file, err)
+	}
+	// объекты бакета файловый сервер не раздает, относительные ссылки /static/<key> никуда бы не вели
+	if cfg.Storage.Backend == "s3" && cfg.FileServer.PublicURL == "" {
+		return Config{}, models.NewError(loc, "fileserver.public_url is required with storage.backend s3", models.ErrInvalidInput)
+	}
+	return cfg, nil
+}
+
+// все ключи должны иметь значение по умолчанию, иначе AutomaticEnv их не увидит при Unmarshal
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("grpc.addr", ":8080")
+	v.SetDefault("grpc.max_image_size", 5<<20)
+	v.SetDefault("grpc.max_message_size", 1<<20)
+
+	v.SetDefault("fileserver.port", 8081)
+	v.SetDefault("fileserver.path", "")
+	v.SetDefault("fileserver.public_url", "")
+	v.SetDefault("fileserver.read_timeout", 5*time.Second)
+	v.SetDefault("fileserver.write_timeout", 10*time.Second)
+	v.SetDefault("fileserver.idle_timeout", time.Minute)
+
+	v.SetDefault("storage.backend", "local")
+	v.SetDefault("storage.layout", "entity")
+	v.SetDefault("storage.path", "/static/image")
+	v.SetDefault("storage.jpeg_quality", 95)
+	v.SetDefault("storage.s3.endpoint", "")
+	v.SetDefault("storage.s3.region", "")
+	v.SetDefault("storage.s3.bucket", "")
+	v.SetDefault("storage.s3.access_key", "")
+	v.SetDefault("storage.s3.secret_key", "")
+	v.SetDefault("storage.s3.use_ssl", true)
+	v.SetDefault("storage.s3.path_style", false)
+	v.SetDefault("storage.s3.unsigned_payload", false)
+	v.SetDefault("storage.s3.part_size", 16<<20)
+	v.SetDefault("storage.s3.timeout", 30*time.Second)
+
+	v.SetDefault("db.dsn", "")
+	v.SetDefault("db.max_conns", 0)
+
+	v.SetDefault("amt.dsn", "")
+	v.SetDefault("amt.queue", "image")
+	v.SetDefault("amt.prefetch", 10)
+	v.SetDefault("amt.workers", 4)
+	v.SetDefault("amt.timeout", 30*time.Second)
+
+	v.SetDefault("shutdown.timeout", 30*time.Second)
+
+	v.SetDefault("health.check_timeout", 2*time.Second)
+	v.SetDefault("health.interval", 5*time.Second)
+}
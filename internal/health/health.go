@@ -0,0 +1,69 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Check - проверка одной зависимости (БД, хранилище, брокер)
+type Check func(ctx context.Context) error
+
+// Checker собирает проверки готовности, общие для /readyz и grpc.health.v1
+type Checker struct {
+	checks   map[string]Check
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+func NewChecker(timeout time.Duration, checks map[string]Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Drain - с этого момента сервис не готов принимать трафик, независимо от зависимостей
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Run выполняет все проверки параллельно, каждую - не дольше timeout.
+// В результате nil означает, что зависимость в порядке
+func (c *Checker) Run(ctx context.Context) map[string]error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	results := make(map[string]error, len(c.checks))
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check(ctx)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Ready возвращает результаты проверок и общий вердикт
+func (c *Checker) Ready(ctx context.Context) (bool, map[string]error) {
+	results := c.Run(ctx)
+	if c.draining.Load() {
+		results["shutdown"] = models.ErrShuttingDown
+		return false, results
+	}
+	for _, err := range results {
+		if err != nil {
+			return false, results
+		}
+	}
+	return true, results
+}
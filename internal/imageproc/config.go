@@ -0,0 +1,145 @@
+package imageproc
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// FromConfig собирает конвейер из настроек сервиса и проверяет параметры шагов
+func FromConfig(steps []config.Step) (*Pipeline, error) {
+	loc := "imageproc.FromConfig"
+	p := &Pipeline{steps: make([]Step, 0, len(steps))}
+	for i, sc := range steps {
+		step, err := stepFromConfig(sc)
+		if err != nil {
+			return nil, models.NewError(loc, fmt.Sprintf("step %d (%s)", i, sc.Type), err)
+		}
+		p.steps = append(p.steps, step)
+	}
+	return p, nil
+}
+
+func stepFromConfig(sc config.Step) (Step, error) {
+	switch sc.Type {
+	case "resize":
+		if sc.Width == 0 && sc.Height == 0 {
+			return nil, errors.New("width or height is required")
+		}
+		return Resize{Width: sc.Width, Height: sc.Height}, nil
+	case "crop":
+		ratio, err := ParseAspect(sc.Aspect)
+		if err != nil {
+			return nil, err
+		}
+		return Crop{Ratio: ratio}, nil
+	case "pad":
+		ratio, err := ParseAspect(sc.Aspect)
+		if err != nil {
+			return nil, err
+		}
+		if sc.Color == "edge" {
+			return Pad{Ratio: ratio, Edge: true}, nil
+		}
+		c := color.Color(color.White)
+		if sc.Color != "" {
+			if c, err = ParseColor(sc.Color); err != nil {
+				return nil, err
+			}
+		}
+		return Pad{Ratio: ratio, Color: c}, nil
+	case "grayscale":
+		return Grayscale{}, nil
+	case "sharpen":
+		if sc.Amount <= 0 {
+			return nil, errors.New("amount must be > 0")
+		}
+		return Sharpen{Amount: sc.Amount}, nil
+	case "encode":
+		format := sc.Format
+		if format == "" {
+			format = "jpeg"
+		}
+		for i, fb := range sc.Fallback {
+			if fb == format || slices.Contains(sc.Fallback[:i], fb) {
+				return nil, fmt.Errorf("fallback %q repeats an output format", fb)
+			}
+		}
+		// webp кодируется без потерь, настройки качества он бы молча проигнорировал
+		if format == "webp" && (sc.Quality > 0 || sc.MaxBytes > 0 || sc.MinQuality > 0) {
+			return nil, errors.New("quality, max_bytes and min_quality apply only to jpeg, webp is lossless")
+		}
+		if sc.Quality > 0 && sc.MinQuality > sc.Quality {
+			return nil, fmt.Errorf("min_quality %d is greater than quality %d", sc.MinQuality, sc.Quality)
+		}
+		return Encode{Format: format, Quality: sc.Quality, MaxBytes: sc.MaxBytes, MinQuality: sc.MinQuality, Fallback: sc.Fallback}, nil
+	case "placeholder":
+		s := Placeholder{ComponentsX: sc.ComponentsX, ComponentsY: sc.ComponentsY}
+		if s.ComponentsX == 0 {
+			s.ComponentsX = 4
+		}
+		if s.ComponentsY == 0 {
+			s.ComponentsY = 3
+		}
+		return s, nil
+	case "palette":
+		colors := sc.Colors
+		if colors == 0 {
+			colors = 5
+		}
+		return Palette{Colors: colors}, nil
+	case "watermark":
+		if sc.Image == "" {
+			return nil, errors.New("image is required")
+		}
+		mark, err := LoadWatermark(sc.Image)
+		if err != nil {
+			return nil, err
+		}
+		s := Watermark{Mark: mark, Position: sc.Position, Scale: sc.Scale, Opacity: sc.Opacity, Margin: sc.Margin}
+		if s.Position == "" {
+			s.Position = "bottom-right"
+		}
+		if s.Scale == 0 {
+			s.Scale = 0.2
+		}
+		if s.Opacity == 0 {
+			s.Opacity = 0.5
+		}
+		if sc.Margin == 0 {
+			s.Margin = 0.03
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown step type %q", sc.Type)
+}
+
+// ParseAspect разбирает соотношение сторон вида "3:4" в ширину / высоту
+func ParseAspect(s string) (float64, error) {
+	w, h, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("aspect %q must look like 3:4", s)
+	}
+	fw, err1 := strconv.ParseFloat(w, 64)
+	fh, err2 := strconv.ParseFloat(h, 64)
+	if err1 != nil || err2 != nil || fw <= 0 || fh <= 0 {
+		return 0, fmt.Errorf("aspect %q must look like 3:4", s)
+	}
+	return fw / fh, nil
+}
+
+// ParseColor разбирает цвет вида "#rrggbb"
+func ParseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("color %q must look like #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
@@ -0,0 +1,138 @@
+package imageproc
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// полосы меньше этого не дают выигрыша - передача между горутинами обходится дороже
+const minBandRows = 16
+
+// parallelRows делит строки [0, h) на полосы и обрабатывает их не более чем
+// в GOMAXPROCS горутинах. Отмена ctx проверяется перед каждой полосой,
+// уже начатые полосы дорабатывают
+func parallelRows(ctx context.Context, h int, fn func(y0, y1 int)) error {
+	workers := runtime.GOMAXPROCS(0)
+	band := max(minBandRows, (h+workers*4-1)/(workers*4))
+	bands := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, (h+band-1)/band) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y0 := range bands {
+				fn(y0, min(y0+band, h))
+			}
+		}()
+	}
+	var err error
+	for y0 := 0; y0 < h; y0 += band {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		bands <- y0
+	}
+	close(bands)
+	wg.Wait()
+	return err
+}
+
+// ToGray переводит изображение в оттенки серого по формуле color.GrayModel.
+// Для *image.YCbCr, *image.RGBA, *image.NRGBA и *image.Gray работает напрямую со срезами Pix
+// без преобразования каждого пикселя через интерфейс color.Color
+func ToGray(ctx context.Context, img image.Image) (*image.Gray, error) {
+	b := img.Bounds()
+	dst := image.NewGray(b)
+	w := b.Dx()
+
+	var row func(y int) // y - номер строки от 0
+	switch src := img.(type) {
+	case *image.Gray:
+		row = func(y int) {
+			i := src.PixOffset(b.Min.X, b.Min.Y+y)
+			copy(dst.Pix[y*dst.Stride:y*dst.Stride+w], src.Pix[i:i+w])
+		}
+	case *image.YCbCr:
+		// Y нельзя взять как есть: для цветов вне гаммы RGB каналы обрезаются,
+		// и серый по GrayModel расходится с яркостью
+		shift := chromaShift(src.SubsampleRatio)
+		row = func(y int) {
+			yi := src.YOffset(b.Min.X, b.Min.Y+y)
+			ci := src.COffset(b.Min.X, b.Min.Y+y) - b.Min.X>>shift
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w]
+			ys := src.Y[yi : yi+w]
+			for x := range d {
+				c := ci + (b.Min.X+x)>>shift
+				// то же, что color.YCbCr.RGBA(), без аллокации интерфейса
+				yy := int32(ys[x]) * 0x10101
+				cb := int32(src.Cb[c]) - 128
+				cr := int32(src.Cr[c]) - 128
+				d[x] = luma(clamp16(yy+91881*cr), clamp16(yy-22554*cb-46802*cr), clamp16(yy+116130*cb))
+			}
+		}
+	case *image.RGBA:
+		row = func(y int) {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w]
+			for x := range d {
+				p := s[x*4 : x*4+3 : x*4+3]
+				d[x] = luma(uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101)
+			}
+		}
+	case *image.NRGBA:
+		row = func(y int) {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w]
+			for x := range d {
+				p := s[x*4 : x*4+4 : x*4+4]
+				// как NRGBA.RGBA(): каналы сначала умножаются на альфу
+				a := uint32(p[3]) * 0x101
+				d[x] = luma(uint32(p[0])*0x101*a/0xffff, uint32(p[1])*0x101*a/0xffff, uint32(p[2])*0x101*a/0xffff)
+			}
+		}
+	default:
+		row = func(y int) {
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+w]
+			for x := range d {
+				d[x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+
+	err := parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row(y)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// luma - то же, что color.GrayModel, для 16-битных premultiplied каналов
+func luma(r, g, b uint32) uint8 {
+	return uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+}
+
+// chromaShift - log2 числа пикселей по горизонтали на один отсчет Cb/Cr
+func chromaShift(ratio image.YCbCrSubsampleRatio) int {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+		return 1
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		return 2
+	}
+	return 0
+}
+
+// clamp16 переводит канал из фиксированной точки YCbCr в 16 бит с обрезкой
+func clamp16(v int32) uint32 {
+	if uint32(v)&0xff000000 == 0 {
+		return uint32(v >> 8)
+	}
+	return uint32(^(v >> 31) & 0xffff)
+}
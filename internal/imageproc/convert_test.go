@@ -0,0 +1,175 @@
+package imageproc
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"maps"
+	"math/rand/v2"
+	"sync/atomic"
+	"testing"
+)
+
+// toGrayAt - прежний попиксельный перевод через At/Set, с ним сравниваются быстрые пути
+func toGrayAt(img image.Image) *image.Gray {
+	b := img.Bounds()
+	dst := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			dst.Set(x, y, color.GrayModel.Convert(img.At(x, y)))
+		}
+	}
+	return dst
+}
+
+func randomYCbCr(r image.Rectangle, ratio image.YCbCrSubsampleRatio, rnd *rand.Rand) *image.YCbCr {
+	img := image.NewYCbCr(r, ratio)
+	for _, pix := range [][]byte{img.Y, img.Cb, img.Cr} {
+		for i := range pix {
+			pix[i] = byte(rnd.UintN(256))
+		}
+	}
+	return img
+}
+
+func randomRGBA(r image.Rectangle, rnd *rand.Rand) *image.RGBA {
+	img := image.NewRGBA(r)
+	for i := 0; i < len(img.Pix); i += 4 {
+		a := byte(rnd.UintN(256))
+		// premultiplied: каналы не больше альфы
+		img.Pix[i] = byte(rnd.UintN(uint(a) + 1))
+		img.Pix[i+1] = byte(rnd.UintN(uint(a) + 1))
+		img.Pix[i+2] = byte(rnd.UintN(uint(a) + 1))
+		img.Pix[i+3] = a
+	}
+	return img
+}
+
+func randomNRGBA(r image.Rectangle, rnd *rand.Rand) *image.NRGBA {
+	img := image.NewNRGBA(r)
+	for i := range img.Pix {
+		img.Pix[i] = byte(rnd.UintN(256))
+	}
+	return img
+}
+
+func randomGray(r image.Rectangle, rnd *rand.Rand) *image.Gray {
+	img := image.NewGray(r)
+	for i := range img.Pix {
+		img.Pix[i] = byte(rnd.UintN(256))
+	}
+	return img
+}
+
+func TestToGray(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	// высота больше minBandRows, чтобы строки делились на несколько полос
+	full := image.Rect(0, 0, 67, 53)
+	offset := image.Rect(-3, 11, 40, 70)
+
+	images := map[string]image.Image{
+		"nrgba":  randomNRGBA(full, rnd),
+		"gray":   randomGray(full, rnd),
+		"rgba":   randomRGBA(full, rnd),
+		"offset": randomRGBA(offset, rnd),
+		"paletted": image.NewPaletted(full, color.Palette{
+			color.RGBA{0x10, 0x80, 0xf0, 0xff}, color.Black}),
+	}
+	for name, ratio := range map[string]image.YCbCrSubsampleRatio{
+		"444": image.YCbCrSubsampleRatio444, "422": image.YCbCrSubsampleRatio422, "420": image.YCbCrSubsampleRatio420,
+		"440": image.YCbCrSubsampleRatio440, "411": image.YCbCrSubsampleRatio411, "410": image.YCbCrSubsampleRatio410,
+	} {
+		images["ycbcr"+name] = randomYCbCr(full, ratio, rnd)
+	}
+	// вырезки с нечетным Min проверяют смещения в Pix и в отсчетах цветности
+	subs := make(map[string]image.Image, len(images))
+	for name, img := range images {
+		r := img.Bounds()
+		subs[name+"/sub"] = img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(image.Rect(r.Min.X+5, r.Min.Y+7, r.Max.X-4, r.Max.Y-3))
+	}
+	maps.Copy(images, subs)
+
+	for name, img := range images {
+		t.Run(name, func(t *testing.T) {
+			got, err := ToGray(context.Background(), img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := img.Bounds()
+			if got.Bounds() != b {
+				t.Fatalf("bounds %v, want %v", got.Bounds(), b)
+			}
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					want := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+					if g := got.GrayAt(x, y).Y; g != want {
+						t.Fatalf("pixel (%d, %d) = %d, want %d", x, y, g, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParallelRowsCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	const h = 100000
+	var rows atomic.Int64
+	err := parallelRows(ctx, h, func(y0, y1 int) {
+		cancel()
+		rows.Add(int64(y1 - y0))
+	})
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	// после отмены выдаются не больше полос, чем уже ждали воркеры
+	if n := rows.Load(); n == 0 || n >= h {
+		t.Fatalf("processed %d rows of %d", n, h)
+	}
+
+	rows.Store(0)
+	if err = parallelRows(ctx, h, func(y0, y1 int) { rows.Add(int64(y1 - y0)) }); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if n := rows.Load(); n != 0 {
+		t.Fatalf("cancelled context processed %d rows", n)
+	}
+}
+
+func BenchmarkToGray(b *testing.B) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	r := image.Rect(0, 0, 4000, 3000)
+	// фото из JPEG: цветность в гамме RGB
+	ycbcr := image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = byte(16 + rnd.UintN(220))
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i] = byte(112 + rnd.UintN(32))
+		ycbcr.Cr[i] = byte(112 + rnd.UintN(32))
+	}
+	images := []struct {
+		name string
+		img  image.Image
+	}{
+		{"YCbCr", ycbcr},
+		{"RGBA", randomRGBA(r, rnd)},
+		{"NRGBA", randomNRGBA(r, rnd)},
+	}
+	for _, tc := range images {
+		b.Run(tc.name+"/At", func(b *testing.B) {
+			for b.Loop() {
+				toGrayAt(tc.img)
+			}
+		})
+		b.Run(tc.name+"/Pix", func(b *testing.B) {
+			for b.Loop() {
+				if _, err := ToGray(context.Background(), tc.img); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Decoded - загруженное изображение, уже в sRGB и развернутое по EXIF
+type Decoded struct {
+	Image       image.Image
+	Format      string // один из Formats
+	Orientation int    // исходный тег EXIF Orientation, OrientationUnknown - тега не было
+	// какое преобразование в sRGB применено: ConversionCMYK, ConversionAdobeRGB...,
+	// ConversionNone - не понадобилось
+	ColorConversion string
+}
+
+// Decode - единственная точка декодирования входящих байт: определяет формат по сигнатуре
+// и сверяет его с formats (пусто - любой из Formats), читает EXIF Orientation,
+// проверяет размеры из заголовка по limits, декодирует, приводит к sRGB по встроенному
+// профилю ICC и поворачивает изображение.
+// У анимированного GIF берется первый кадр.
+// От исходного файла остаются только пиксели, дальше они заново кодируются
+// хранилищем - EXIF (в том числе GPS) и прочие метаданные в сохраненные файлы не попадают.
+// Временная копия загрузки хранится в исходных байтах, но тоже без них - см. StripMetadata
+func Decode(ctx context.Context, data []byte, formats []string, limits Limits) (Decoded, error) {
+	loc := "imageproc.Decode"
+	format := Sniff(data)
+	if err := checkFormat(format, formats); err != nil {
+		return Decoded{}, models.NewError(loc, "sniff", err)
+	}
+	// заголовок читается без пикселей - маленький файл может заявить 50000x50000
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Decoded{}, models.NewError(loc, "decode config", err)
+	}
+	orientation := ExifOrientation(data)
+	w, h := cfg.Width, cfg.Height
+	if orientation >= OrientationTranspose {
+		w, h = h, w
+	}
+	if err = limits.Check(w, h); err != nil {
+		return Decoded{}, models.NewError(loc, fmt.Sprintf("%dx%d", w, h), err)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Decoded{}, models.NewError(loc, "decode", err)
+	}
+	// профиль читается только здесь: при сохранении хранилище его не пишет, так что
+	// без преобразования цвета изображения в другом пространстве выглядели бы тусклыми
+	img, conversion, err := ToSRGB(ctx, img, ICCProfile(data))
+	if err != nil {
+		return Decoded{}, models.NewError(loc, "color conversion", err)
+	}
+	img, err = Orient(ctx, img, orientation)
+	if err != nil {
+		return Decoded{}, models.NewError(loc, "orient", err)
+	}
+	return Decoded{Image: img, Format: format, Orientation: orientation, ColorConversion: conversion}, nil
+}
+
+// Orient приводит изображение с тегом orientation к нормальному положению.
+// Для OrientationNormal и OrientationUnknown возвращает img как есть
+func Orient(ctx context.Context, img image.Image, orientation int) (image.Image, error) {
+	if orientation <= OrientationNormal || orientation > OrientationRotate270 {
+		return img, nil
+	}
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= OrientationTranspose {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	// для пикселя результата (x, y) - координаты в исходнике
+	var at func(x, y int) (int, int)
+	switch orientation {
+	case OrientationMirrorH:
+		at = func(x, y int) (int, int) { return w - 1 - x, y }
+	case OrientationRotate180:
+		at = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case OrientationMirrorV:
+		at = func(x, y int) (int, int) { return x, h - 1 - y }
+	case OrientationTranspose:
+		at = func(x, y int) (int, int) { return y, x }
+	case OrientationRotate90:
+		at = func(x, y int) (int, int) { return y, h - 1 - x }
+	case OrientationTransverse:
+		at = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case OrientationRotate270:
+		at = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	err := parallelRows(ctx, dh, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+dw*4]
+			for x := 0; x < dw; x++ {
+				sx, sy := at(x, y)
+				i := sy*src.Stride + sx*4
+				copy(d[x*4:x*4+4], src.Pix[i:i+4])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
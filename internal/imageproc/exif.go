@@ -0,0 +1,151 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// значения тега EXIF Orientation
+const (
+	OrientationUnknown    = 0 // тега нет или он не читается
+	OrientationNormal     = 1
+	OrientationMirrorH    = 2
+	OrientationRotate180  = 3
+	OrientationMirrorV    = 4
+	OrientationTranspose  = 5
+	OrientationRotate90   = 6 // для показа повернуть на 90° по часовой
+	OrientationTransverse = 7
+	OrientationRotate270  = 8 // для показа повернуть на 90° против часовой
+)
+
+const exifOrientationTag = 0x0112
+
+var (
+	jpegSOI    = []byte{0xff, 0xd8}
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader = []byte("Exif\x00\x00")
+)
+
+// ExifOrientation читает тег Orientation из сырых байт JPEG (сегмент APP1),
+// PNG (чанк eXIf), WebP (чанк EXIF) или TIFF (IFD0 самого файла).
+// Если тега нет или блок поврежден - OrientationUnknown:
+// ошибка в метаданных не повод отказываться от изображения
+func ExifOrientation(data []byte) int {
+	var tiff []byte
+	switch Sniff(data) {
+	case "jpeg":
+		tiff = jpegExif(data[len(jpegSOI):])
+	case "png":
+		tiff = pngExif(data[len(pngMagic):])
+	case "webp":
+		tiff = webpExif(data[12:])
+	case "tiff":
+		tiff = data
+	}
+	if tiff == nil {
+		return OrientationUnknown
+	}
+	o := tiffOrientation(tiff)
+	if o < OrientationNormal || o > OrientationRotate270 {
+		return OrientationUnknown
+	}
+	return o
+}
+
+// jpegExif идет по сегментам до начала данных скана и возвращает TIFF блок из APP1 Exif
+func jpegExif(data []byte) []byte {
+	for len(data) >= 4 {
+		if data[0] != 0xff {
+			return nil
+		}
+		marker := data[1]
+		switch {
+		case marker == 0xff: // заполняющий байт
+			data = data[1:]
+			continue
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7: // маркеры без длины
+			data = data[2:]
+			continue
+		case marker == 0xda || marker == 0xd9: // SOS, EOI - дальше метаданных нет
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(data[2:4]))
+		if n < 2 || len(data) < 2+n {
+			return nil
+		}
+		segment := data[4 : 2+n]
+		if marker == 0xe1 && bytes.HasPrefix(segment, exifHeader) {
+			return segment[len(exifHeader):]
+		}
+		data = data[2+n:]
+	}
+	return nil
+}
+
+// pngExif ищет чанк eXIf - в нем TIFF блок без заголовка Exif
+func pngExif(data []byte) []byte {
+	for len(data) >= 12 {
+		n := binary.BigEndian.Uint32(data[:4])
+		typ := string(data[4:8])
+		if uint64(n)+12 > uint64(len(data)) {
+			return nil
+		}
+		switch typ {
+		case "eXIf":
+			return data[8 : 8+n]
+		case "IEND":
+			return nil
+		}
+		data = data[12+n:]
+	}
+	return nil
+}
+
+// webpExif ищет чанк EXIF в контейнере RIFF. Часть программ пишет
+// в него заголовок Exif, как в JPEG, часть - сразу TIFF блок
+func webpExif(data []byte) []byte {
+	for len(data) >= 8 {
+		n := uint64(binary.LittleEndian.Uint32(data[4:8]))
+		if n+8 > uint64(len(data)) {
+			return nil
+		}
+		if string(data[:4]) == "EXIF" {
+			return bytes.TrimPrefix(data[8:8+n], exifHeader)
+		}
+		data = data[min(8+n+n%2, uint64(len(data))):] // чанки выровнены по 2 байтам
+	}
+	return nil
+}
+
+// tiffOrientation читает Orientation из IFD0
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return OrientationUnknown
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return OrientationUnknown
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return OrientationUnknown
+	}
+	ifd := uint64(order.Uint32(tiff[4:8]))
+	if ifd+2 > uint64(len(tiff)) {
+		return OrientationUnknown
+	}
+	count := uint64(order.Uint16(tiff[ifd:]))
+	entries := tiff[ifd+2:]
+	for i := uint64(0); i < count && i*12+12 <= uint64(len(entries)); i++ {
+		e := entries[i*12 : i*12+12]
+		// тип 3 - SHORT, значение лежит прямо в поле смещения
+		if order.Uint16(e[0:2]) == exifOrientationTag && order.Uint16(e[2:4]) == 3 {
+			return int(order.Uint16(e[8:10]))
+		}
+	}
+	return OrientationUnknown
+}
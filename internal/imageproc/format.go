@@ -0,0 +1,95 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Formats - входные форматы, для которых зарегистрированы декодеры.
+// Имена совпадают с теми, что возвращает image.Decode
+var Formats = []string{"jpeg", "png", "gif", "webp", "bmp", "tiff"}
+
+// DefaultFormats - что принимает сервис, если в настройках formats не задан
+var DefaultFormats = []string{"jpeg", "png"}
+
+// OutputFormats - в каких форматах хранилище умеет сохранять
+var OutputFormats = []string{"jpeg", "webp"}
+
+// Sniff определяет формат по сигнатуре в начале файла, а не по расширению
+// или заявленному клиентом типу. Пустая строка - формат не распознан
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8, 0xff}):
+		return "jpeg"
+	case bytes.HasPrefix(data, pngMagic):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && bytes.Equal(data[:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(data, []byte("BM")):
+		return "bmp"
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "tiff"
+	}
+	return ""
+}
+
+// FormatError возвращается, если формат не распознан или не разрешен сервису
+type FormatError struct {
+	Format  string // "" - не распознан
+	Allowed []string
+}
+
+func (e *FormatError) Error() string {
+	format := e.Format
+	if format == "" {
+		format = "unknown"
+	}
+	return fmt.Sprintf("unsupported format %s, allowed: %s", format, strings.Join(e.Allowed, ", "))
+}
+
+func (e *FormatError) Unwrap() error {
+	return models.ErrInvalidInput
+}
+
+// checkFormat - allowed пустой означает любой из Formats
+func checkFormat(format string, allowed []string) error {
+	if format == "" || len(allowed) > 0 && !slices.Contains(allowed, format) {
+		if len(allowed) == 0 {
+			allowed = Formats
+		}
+		return &FormatError{Format: format, Allowed: allowed}
+	}
+	return nil
+}
+
+// Flatten накладывает полупрозрачное изображение на фон bg -
+// для форматов без альфа-канала (JPEG), иначе прозрачное становится черным.
+// Непрозрачное изображение возвращается как есть
+func Flatten(img image.Image, bg color.Color) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
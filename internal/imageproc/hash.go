@@ -0,0 +1,65 @@
+package imageproc
+
+import (
+	"context"
+	"image"
+	"math/bits"
+)
+
+// сетка dHash: 9 столбцов дают 8 сравнений соседей в каждой из 8 строк - 64 бита
+const (
+	hashCols = 9
+	hashRows = 8
+)
+
+// DHash - разностный перцептивный хеш. Изображение в оттенках серого усредняется
+// до сетки 9x8, бит выставлен, если клетка ярче соседки справа. Хеш не меняется
+// от масштаба и пересжатия, поэтому похожие снимки отличаются на несколько бит
+func DHash(ctx context.Context, img image.Image) (uint64, error) {
+	gray, err := ToGray(ctx, img)
+	if err != nil {
+		return 0, err
+	}
+	b := gray.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0, nil
+	}
+
+	// среднее по прямоугольнику исходника, который попадает в клетку,
+	// у маленьких изображений клетки перекрываются, но не бывают пустыми
+	var cells [hashRows][hashCols]uint64
+	for r := 0; r < hashRows; r++ {
+		y0, y1 := r*h/hashRows, max((r+1)*h/hashRows, r*h/hashRows+1)
+		for c := 0; c < hashCols; c++ {
+			x0, x1 := c*w/hashCols, max((c+1)*w/hashCols, c*w/hashCols+1)
+			var sum uint64
+			for y := y0; y < y1; y++ {
+				row := gray.Pix[y*gray.Stride:]
+				for x := x0; x < x1; x++ {
+					sum += uint64(row[x])
+				}
+			}
+			cells[r][c] = sum / uint64((y1-y0)*(x1-x0))
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	var hash uint64
+	for r := 0; r < hashRows; r++ {
+		for c := 0; c < hashCols-1; c++ {
+			hash <<= 1
+			if cells[r][c] > cells[r][c+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// HashDistance - число различающихся бит двух хешей (расстояние Хэмминга), от 0 до 64
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,417 @@
+package imageproc
+
+import (
+	"bytes"
+	"cmp"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"slices"
+
+	"golang.org/x/image/draw"
+)
+
+// какое преобразование цвета применено при загрузке, "" - изображение уже в sRGB
+// или его профиль не распознан
+const (
+	ConversionNone      = ""
+	ConversionCMYK      = "cmyk"
+	ConversionAdobeRGB  = "adobe-rgb"
+	ConversionDisplayP3 = "display-p3"
+)
+
+// больше профиль не бывает у реальных файлов, а распакованный iCCP иначе не ограничен
+const maxICCSize = 4 << 20
+
+var jpegICCHeader = []byte("ICC_PROFILE\x00")
+
+// тег TIFF с профилем ICC (InterColorProfile)
+const tiffICCTag = 34675
+
+// ICCProfile возвращает встроенный профиль ICC из сырых байт JPEG (сегменты APP2, профиль
+// может быть разбит на несколько), PNG (чанк iCCP), WebP (чанк ICCP) или TIFF (тег 34675).
+// Профиля нет или он поврежден - nil
+func ICCProfile(data []byte) []byte {
+	switch Sniff(data) {
+	case "jpeg":
+		return jpegICC(data[len(jpegSOI):])
+	case "png":
+		return pngICC(data[len(pngMagic):])
+	case "webp":
+		return webpICC(data[12:])
+	case "tiff":
+		return tiffICC(data)
+	}
+	return nil
+}
+
+// jpegICC собирает профиль из сегментов APP2 по их порядковым номерам
+func jpegICC(data []byte) []byte {
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	total := byte(0)
+loop:
+	for len(data) >= 4 {
+		if data[0] != 0xff {
+			return nil
+		}
+		marker := data[1]
+		switch {
+		case marker == 0xff:
+			data = data[1:]
+			continue
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			data = data[2:]
+			continue
+		case marker == 0xda || marker == 0xd9:
+			break loop
+		}
+		n := int(binary.BigEndian.Uint16(data[2:4]))
+		if n < 2 || len(data) < 2+n {
+			return nil
+		}
+		segment := data[4 : 2+n]
+		// ICC_PROFILE\0, номер куска с 1, число кусков
+		if marker == 0xe2 && bytes.HasPrefix(segment, jpegICCHeader) && len(segment) >= len(jpegICCHeader)+2 {
+			total = segment[len(jpegICCHeader)+1]
+			chunks = append(chunks, chunk{seq: segment[len(jpegICCHeader)], data: segment[len(jpegICCHeader)+2:]})
+		}
+		data = data[2+n:]
+	}
+	if len(chunks) == 0 || len(chunks) != int(total) {
+		return nil
+	}
+	slices.SortFunc(chunks, func(a, b chunk) int { return cmp.Compare(a.seq, b.seq) })
+	var profile []byte
+	for i, c := range chunks {
+		if int(c.seq) != i+1 {
+			return nil
+		}
+		profile = append(profile, c.data...)
+	}
+	return profile
+}
+
+// pngICC распаковывает чанк iCCP: имя профиля, 0, метод сжатия (0 - zlib), сжатый профиль
+func pngICC(data []byte) []byte {
+	for len(data) >= 12 {
+		n := binary.BigEndian.Uint32(data[:4])
+		typ := string(data[4:8])
+		if uint64(n)+12 > uint64(len(data)) {
+			return nil
+		}
+		switch typ {
+		case "iCCP":
+			body := data[8 : 8+n]
+			i := bytes.IndexByte(body, 0)
+			if i < 0 || i+2 > len(body) || body[i+1] != 0 {
+				return nil
+			}
+			r, err := zlib.NewReader(bytes.NewReader(body[i+2:]))
+			if err != nil {
+				return nil
+			}
+			defer r.Close()
+			profile, err := io.ReadAll(io.LimitReader(r, maxICCSize))
+			if err != nil {
+				return nil
+			}
+			return profile
+		case "IDAT", "IEND": // iCCP всегда до данных
+			return nil
+		}
+		data = data[12+n:]
+	}
+	return nil
+}
+
+func webpICC(data []byte) []byte {
+	for len(data) >= 8 {
+		n := uint64(binary.LittleEndian.Uint32(data[4:8]))
+		if n+8 > uint64(len(data)) {
+			return nil
+		}
+		if string(data[:4]) == "ICCP" {
+			return data[8 : 8+n]
+		}
+		data = data[min(8+n+n%2, uint64(len(data))):]
+	}
+	return nil
+}
+
+// tiffICC ищет тег профиля в IFD0, значение больше 4 байт всегда лежит по смещению
+func tiffICC(tiff []byte) []byte {
+	if len(tiff) < 8 {
+		return nil
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	ifd := uint64(order.Uint32(tiff[4:8]))
+	if ifd+2 > uint64(len(tiff)) {
+		return nil
+	}
+	count := uint64(order.Uint16(tiff[ifd:]))
+	entries := tiff[ifd+2:]
+	for i := uint64(0); i < count && i*12+12 <= uint64(len(entries)); i++ {
+		e := entries[i*12 : i*12+12]
+		if order.Uint16(e[0:2]) != tiffICCTag {
+			continue
+		}
+		n, off := uint64(order.Uint32(e[4:8])), uint64(order.Uint32(e[8:12]))
+		if n <= 4 || off+n > uint64(len(tiff)) {
+			return nil
+		}
+		return tiff[off : off+n]
+	}
+	return nil
+}
+
+// rgbSpace - RGB пространство с белой точкой D65: матрица в линейный sRGB и кривая кодирования
+type rgbSpace struct {
+	name string
+	// колоранты rXYZ, gXYZ, bXYZ из профиля, приведенные к D50, - по ним узнается профиль
+	colorants [3][3]float64
+	toSRGB    [3][3]float64
+	decode    func(v float64) float64
+}
+
+// допуск на колоранты: разные производители округляют их по-разному
+const colorantTolerance = 0.01
+
+var rgbSpaces = []rgbSpace{
+	{
+		name:      ConversionAdobeRGB,
+		colorants: [3][3]float64{{0.6097, 0.3111, 0.0195}, {0.2053, 0.6257, 0.0609}, {0.1492, 0.0632, 0.7446}},
+		toSRGB:    linearToSRGB([3][2]float64{{0.64, 0.33}, {0.21, 0.71}, {0.15, 0.06}}),
+		decode:    func(v float64) float64 { return math.Pow(v, 563.0/256) },
+	},
+	{
+		name:      ConversionDisplayP3,
+		colorants: [3][3]float64{{0.5151, 0.2412, -0.0011}, {0.2920, 0.6922, 0.0419}, {0.1571, 0.0666, 0.7841}},
+		toSRGB:    linearToSRGB([3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}),
+		decode:    srgbDecode,
+	},
+}
+
+// profileSpace узнает RGB профиль по колорантам, nil - профиль sRGB, не RGB
+// или неизвестный: такое изображение остается как есть
+func profileSpace(profile []byte) *rgbSpace {
+	if len(profile) < 132 || string(profile[16:20]) != "RGB " {
+		return nil
+	}
+	var colorants [3][3]float64
+	found := 0
+	count := uint64(binary.BigEndian.Uint32(profile[128:132]))
+	for i := uint64(0); i < count && 132+i*12+12 <= uint64(len(profile)); i++ {
+		e := profile[132+i*12:]
+		c := -1
+		switch string(e[:4]) {
+		case "rXYZ":
+			c = 0
+		case "gXYZ":
+			c = 1
+		case "bXYZ":
+			c = 2
+		}
+		off, n := uint64(binary.BigEndian.Uint32(e[4:8])), uint64(binary.BigEndian.Uint32(e[8:12]))
+		if c < 0 || n < 20 || off+20 > uint64(len(profile)) || string(profile[off:off+4]) != "XYZ " {
+			continue
+		}
+		for k := 0; k < 3; k++ {
+			colorants[c][k] = float64(int32(binary.BigEndian.Uint32(profile[off+8+uint64(k)*4:]))) / 65536
+		}
+		found++
+	}
+	if found != 3 {
+		return nil
+	}
+	for i := range rgbSpaces {
+		if colorantsMatch(colorants, rgbSpaces[i].colorants) {
+			return &rgbSpaces[i]
+		}
+	}
+	return nil
+}
+
+func colorantsMatch(a, b [3][3]float64) bool {
+	for i := 0; i < 3; i++ {
+		for k := 0; k < 3; k++ {
+			if math.Abs(a[i][k]-b[i][k]) > colorantTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ToSRGB приводит декодированное изображение к sRGB: CMYK переводится простой формулой
+// без профиля (у JPEG из типографии он почти всегда есть, но это таблица, а не матрица),
+// RGB с профилем Adobe RGB или Display P3 - через линейный RGB. Возвращает изображение
+// и примененное преобразование, ConversionNone - изображение не менялось
+func ToSRGB(ctx context.Context, img image.Image, profile []byte) (image.Image, string, error) {
+	if cmyk, ok := img.(*image.CMYK); ok {
+		dst, err := cmykToNRGBA(ctx, cmyk)
+		if err != nil {
+			return nil, ConversionNone, err
+		}
+		return dst, ConversionCMYK, nil
+	}
+	space := profileSpace(profile)
+	if space == nil {
+		return img, ConversionNone, nil
+	}
+	// серые изображения профиль RGB не описывает
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return img, ConversionNone, nil
+	}
+	dst, err := space.convert(ctx, img)
+	if err != nil {
+		return nil, ConversionNone, err
+	}
+	return dst, space.name, nil
+}
+
+func cmykToNRGBA(ctx context.Context, src *image.CMYK) (*image.NRGBA, error) {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	err := parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			d := dst.Pix[y*dst.Stride:]
+			for x := 0; x < b.Dx(); x++ {
+				c, m, ye, k := uint32(s[x*4]), uint32(s[x*4+1]), uint32(s[x*4+2]), uint32(s[x*4+3])
+				w := 255 - k
+				d[x*4] = uint8((255 - c) * w / 255)
+				d[x*4+1] = uint8((255 - m) * w / 255)
+				d[x*4+2] = uint8((255 - ye) * w / 255)
+				d[x*4+3] = 0xff
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// уровней в таблице кодирования линейного значения обратно в 8 бит sRGB
+const encodeLevels = 4096
+
+var srgbEncodeTable = func() [encodeLevels + 1]uint8 {
+	var t [encodeLevels + 1]uint8
+	for i := range t {
+		t[i] = uint8(math.Round(srgbEncode(float64(i)/encodeLevels) * 255))
+	}
+	return t
+}()
+
+func (s *rgbSpace) convert(ctx context.Context, img image.Image) (*image.NRGBA, error) {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	var linear [256]float64
+	for i := range linear {
+		linear[i] = s.decode(float64(i) / 255)
+	}
+	m := s.toSRGB
+	err := parallelRows(ctx, b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			d := dst.Pix[y*dst.Stride : y*dst.Stride+b.Dx()*4]
+			for x := 0; x < len(d); x += 4 {
+				r, g, bl := linear[d[x]], linear[d[x+1]], linear[d[x+2]]
+				for c := 0; c < 3; c++ {
+					v := m[c][0]*r + m[c][1]*g + m[c][2]*bl
+					// цвета вне охвата sRGB обрезаются
+					d[x+c] = srgbEncodeTable[int(math.Round(min(max(v, 0), 1)*encodeLevels))]
+				}
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// белая точка D65 и основные цвета sRGB в координатах xy
+var (
+	d65        = [2]float64{0.3127, 0.3290}
+	srgbPrimes = [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}}
+)
+
+// linearToSRGB - матрица из линейного RGB с основными цветами primaries (белая точка D65)
+// в линейный sRGB
+func linearToSRGB(primaries [3][2]float64) [3][3]float64 {
+	return mul3(inv3(rgbToXYZ(srgbPrimes)), rgbToXYZ(primaries))
+}
+
+// rgbToXYZ - матрица линейный RGB -> XYZ: столбцы - XYZ основных цветов,
+// масштабированные так, чтобы (1, 1, 1) давал белую точку D65
+func rgbToXYZ(p [3][2]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		x, y := p[i][0], p[i][1]
+		m[0][i], m[1][i], m[2][i] = x/y, 1, (1-x-y)/y
+	}
+	white := [3]float64{d65[0] / d65[1], 1, (1 - d65[0] - d65[1]) / d65[1]}
+	inv := inv3(m)
+	for i := 0; i < 3; i++ {
+		s := inv[i][0]*white[0] + inv[i][1]*white[1] + inv[i][2]*white[2]
+		for r := 0; r < 3; r++ {
+			m[r][i] *= s
+		}
+	}
+	return m
+}
+
+func mul3(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				m[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return m
+}
+
+func inv3(m [3][3]float64) [3][3]float64 {
+	a, b, c := m[0][0], m[0][1], m[0][2]
+	d, e, f := m[1][0], m[1][1], m[1][2]
+	g, h, i := m[2][0], m[2][1], m[2][2]
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+	return [3][3]float64{
+		{(e*i - f*h) / det, (c*h - b*i) / det, (b*f - c*e) / det},
+		{(f*g - d*i) / det, (a*i - c*g) / det, (c*d - a*f) / det},
+		{(d*h - e*g) / det, (b*g - a*h) / det, (a*e - b*d) / det},
+	}
+}
@@ -0,0 +1,91 @@
+package imageproc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Limits - допустимые размеры изображения после поворота по EXIF.
+// Проверяются по заголовку (image.DecodeConfig) до выделения памяти под пиксели.
+// Нулевое поле не проверяется
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+	MinWidth  int
+	MinHeight int
+}
+
+// DefaultLimits - для сервисов без настроек и для незаданных полей limits.
+// 50 Мп в RGBA - около 200 МБ, больше на одну загрузку выделять нельзя
+var DefaultLimits = Limits{MaxWidth: 12000, MaxHeight: 12000, MaxPixels: 50_000_000}
+
+func LimitsFromConfig(cfg config.Limits) Limits {
+	l := DefaultLimits
+	if cfg.MaxWidth > 0 {
+		l.MaxWidth = cfg.MaxWidth
+	}
+	if cfg.MaxHeight > 0 {
+		l.MaxHeight = cfg.MaxHeight
+	}
+	if cfg.MaxMegapixels > 0 {
+		l.MaxPixels = int(cfg.MaxMegapixels * 1_000_000)
+	}
+	l.MinWidth = cfg.MinWidth
+	l.MinHeight = cfg.MinHeight
+	return l
+}
+
+// LimitViolation - одно нарушенное ограничение, Field - width, height или pixels
+type LimitViolation struct {
+	Field       string
+	Description string
+}
+
+// LimitError возвращается, если размеры изображения не проходят Limits
+type LimitError struct {
+	Violations []LimitViolation
+}
+
+func (e *LimitError) Error() string {
+	descs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		descs = append(descs, v.Description)
+	}
+	return "image dimensions out of limits: " + strings.Join(descs, "; ")
+}
+
+func (e *LimitError) Unwrap() error {
+	return models.ErrInvalidInput
+}
+
+// Check возвращает *LimitError со всеми нарушениями или nil
+func (l Limits) Check(width, height int) error {
+	var vs []LimitViolation
+	if width <= 0 || height <= 0 {
+		vs = append(vs, LimitViolation{"width", fmt.Sprintf("empty image %dx%d", width, height)})
+	}
+	if l.MaxWidth > 0 && width > l.MaxWidth {
+		vs = append(vs, LimitViolation{"width", fmt.Sprintf("width %d is greater than %d", width, l.MaxWidth)})
+	}
+	if l.MaxHeight > 0 && height > l.MaxHeight {
+		vs = append(vs, LimitViolation{"height", fmt.Sprintf("height %d is greater than %d", height, l.MaxHeight)})
+	}
+	// в int64, чтобы заявленные в заголовке размеры не переполнили произведение
+	if l.MaxPixels > 0 && int64(width)*int64(height) > int64(l.MaxPixels) {
+		vs = append(vs, LimitViolation{"pixels", fmt.Sprintf("%dx%d is more than %.1f megapixels", width, height, float64(l.MaxPixels)/1e6)})
+	}
+	if width < l.MinWidth {
+		vs = append(vs, LimitViolation{"width", fmt.Sprintf("width %d is less than %d", width, l.MinWidth)})
+	}
+	if height < l.MinHeight {
+		vs = append(vs, LimitViolation{"height", fmt.Sprintf("height %d is less than %d", height, l.MinHeight)})
+	}
+	if len(vs) > 0 {
+		return &LimitError{Violations: vs}
+	}
+	return nil
+}
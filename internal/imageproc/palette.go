@@ -0,0 +1,250 @@
+package imageproc
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+	"golang.org/x/image/draw"
+)
+
+// ColorNames - фиксированный набор названий цветов для фильтра "по цвету" в каталоге
+var ColorNames = []string{"black", "white", "gray", "red", "orange", "yellow", "green",
+	"teal", "blue", "purple", "pink", "brown", "beige"}
+
+// Palette выделяет Colors основных цветов изображения медианным сечением и дает каждому
+// название из ColorNames. Ставится до pad: поля иначе попадут в палитру как отдельный цвет
+type Palette struct {
+	Colors int
+}
+
+func (s Palette) Apply(ctx context.Context, f *Frame) error {
+	f.Palette = ExtractPalette(sample(f.Image, placeholderSize), s.Colors)
+	return ctx.Err()
+}
+
+// сколько итераций k-средних уточняют группы медианного сечения
+const paletteRefineSteps = 8
+
+// ExtractPalette делит пиксели медианным сечением на n групп (меньше, если пикселей мало),
+// уточняет группы несколькими итерациями k-средних и возвращает их средние цвета
+// по убыванию доли. Почти прозрачные пиксели не учитываются
+func ExtractPalette(img image.Image, n int) []models.PaletteColor {
+	b := img.Bounds()
+	pixels := make([][3]uint8, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A >= 128 {
+				pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+	if len(pixels) == 0 || n <= 0 {
+		return nil
+	}
+
+	boxes := [][][3]uint8{pixels}
+	for len(boxes) < n {
+		// делится группа с самым большим разбросом по одному из каналов
+		split, channel, spread := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			if ch, sp := widestChannel(box); sp > spread {
+				split, channel, spread = i, ch, sp
+			}
+		}
+		if split < 0 {
+			break // все группы однотонные
+		}
+		box := boxes[split]
+		slices.SortFunc(box, func(a, b [3]uint8) int { return cmp.Compare(a[channel], b[channel]) })
+		mid := splitPoint(box, channel)
+		boxes[split] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	// медианное сечение режет по одному каналу и смешивает соседние по нему цвета,
+	// k-средние с его центрами в качестве начальных разводят их обратно
+	centers := make([][3]float64, len(boxes))
+	for i, box := range boxes {
+		centers[i] = mean(box)
+	}
+	counts := make([]int, len(centers))
+	for step := 0; step < paletteRefineSteps; step++ {
+		sums := make([][3]float64, len(centers))
+		clear(counts)
+		for _, p := range pixels {
+			i := nearest(centers, p)
+			counts[i]++
+			for c := 0; c < 3; c++ {
+				sums[i][c] += float64(p[c])
+			}
+		}
+		moved := false
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			next := [3]float64{sums[i][0] / float64(counts[i]), sums[i][1] / float64(counts[i]), sums[i][2] / float64(counts[i])}
+			moved = moved || next != centers[i]
+			centers[i] = next
+		}
+		if !moved {
+			break
+		}
+	}
+
+	palette := make([]models.PaletteColor, 0, len(centers))
+	for i, center := range centers {
+		if counts[i] == 0 {
+			continue
+		}
+		r, g, b := uint8(math.Round(center[0])), uint8(math.Round(center[1])), uint8(math.Round(center[2]))
+		palette = append(palette, models.PaletteColor{
+			Color: fmt.Sprintf("#%02x%02x%02x", r, g, b),
+			Name:  ColorName(r, g, b),
+			Share: float64(counts[i]) / float64(len(pixels)),
+		})
+	}
+	slices.SortStableFunc(palette, func(a, b models.PaletteColor) int { return cmp.Compare(b.Share, a.Share) })
+	return palette
+}
+
+// splitPoint - граница смены значения канала, ближайшая к медиане: пиксели одного цвета
+// не расходятся по разным группам, иначе у плоской графики получались бы смешанные цвета
+func splitPoint(box [][3]uint8, channel int) int {
+	mid := len(box) / 2
+	for d := 0; d < len(box); d++ {
+		for _, i := range [2]int{mid - d, mid + d} {
+			if i > 0 && i < len(box) && box[i][channel] != box[i-1][channel] {
+				return i
+			}
+		}
+	}
+	return mid
+}
+
+func mean(box [][3]uint8) [3]float64 {
+	var sum [3]float64
+	for _, p := range box {
+		for c := 0; c < 3; c++ {
+			sum[c] += float64(p[c])
+		}
+	}
+	n := float64(len(box))
+	return [3]float64{sum[0] / n, sum[1] / n, sum[2] / n}
+}
+
+func nearest(centers [][3]float64, p [3]uint8) int {
+	best, bestDist := 0, math.Inf(1)
+	for i, c := range centers {
+		dr, dg, db := c[0]-float64(p[0]), c[1]-float64(p[1]), c[2]-float64(p[2])
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func widestChannel(box [][3]uint8) (int, int) {
+	lo, hi := [3]uint8{255, 255, 255}, [3]uint8{}
+	for _, p := range box {
+		for c := 0; c < 3; c++ {
+			lo[c] = min(lo[c], p[c])
+			hi[c] = max(hi[c], p[c])
+		}
+	}
+	channel := 0
+	for c := 1; c < 3; c++ {
+		if hi[c]-lo[c] > hi[channel]-lo[channel] {
+			channel = c
+		}
+	}
+	return channel, int(hi[channel] - lo[channel])
+}
+
+// ColorName относит цвет к одному из ColorNames по тону, светлоте и насыщенности.
+// Насыщенность берется как разница максимального и минимального канала (chroma):
+// HSL-насыщенность у светлых цветов завышена и бледные тона уходили бы в яркие
+func ColorName(r, g, b uint8) string {
+	h, c, l := toHCL(r, g, b)
+	switch {
+	case l < 0.12:
+		return "black"
+	case l > 0.92:
+		return "white"
+	case c < 0.1:
+		if l < 0.25 {
+			return "black"
+		}
+		if l > 0.85 {
+			return "white"
+		}
+		return "gray"
+	case h >= 20 && h < 60 && c < 0.35 && l >= 0.6:
+		return "beige"
+	case (h < 15 || h >= 345) && l > 0.75:
+		return "pink"
+	case h < 15 || h >= 345:
+		return "red"
+	case h < 45 && l < 0.45:
+		return "brown"
+	case h < 45:
+		return "orange"
+	case h < 65 && l < 0.3:
+		return "brown"
+	case h < 65:
+		return "yellow"
+	case h < 165:
+		return "green"
+	case h < 195:
+		return "teal"
+	case h < 255:
+		return "blue"
+	case h < 290, h < 330 && l < 0.4:
+		return "purple"
+	}
+	return "pink"
+}
+
+// toHCL - тон в градусах [0, 360), chroma и светлота HSL в [0, 1]
+func toHCL(r, g, b uint8) (float64, float64, float64) {
+	rf, gf, bf := float64(r)/255, float64(g)/255, float64(b)/255
+	hi, lo := max(rf, gf, bf), min(rf, gf, bf)
+	l := (hi + lo) / 2
+	d := hi - lo
+	if d == 0 {
+		return 0, 0, l
+	}
+	var h float64
+	switch hi {
+	case rf:
+		h = math.Mod((gf-bf)/d, 6)
+	case gf:
+		h = (bf-rf)/d + 2
+	default:
+		h = (rf-gf)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, d, l
+}
+
+// sample - уменьшенная копия, вписанная в size x size, с сохранением прозрачности
+func sample(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := fitInside(b.Dx(), b.Dy(), size, size)
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
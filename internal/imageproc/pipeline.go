@@ -0,0 +1,66 @@
+package imageproc
+
+import (
+	"context"
+	"image"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Frame - изображение, которое проходит через конвейер, и всё,
+// что шаги решили о нем сообщить хранилищу
+type Frame struct {
+	Image image.Image
+	// куда смещать обрезку: точка интереса клиента в долях текущего кадра (шаги, меняющие кадр,
+	// ее пересчитывают), без нее при Smart окно ищется по деталям, иначе обрезка по центру
+	Focus  *models.FocalPoint
+	Smart  bool
+	Encode models.EncodeOptions
+	// форматы запасных копий основного изображения для клиентов, не понимающих Encode.Format
+	Fallback []string
+	// заглушка для фронтенда, пока изображение грузится, "" - шага placeholder не было
+	BlurHash      string
+	DominantColor string
+	// основные цвета для фильтра по цвету, nil - шага palette не было
+	Palette []models.PaletteColor
+}
+
+// Step - один шаг обработки. Шаг не должен менять входное изображение на месте,
+// так как исходник может понадобиться другим шагам или вариантам
+type Step interface {
+	Apply(ctx context.Context, f *Frame) error
+}
+
+// Pipeline - упорядоченный список шагов, настраивается для каждого сервиса отдельно
+type Pipeline struct {
+	steps []Step
+}
+
+func NewPipeline(steps ...Step) *Pipeline {
+	return &Pipeline{steps: steps}
+}
+
+// Format - формат, в котором хранилище сохранит результат: из последнего шага Encode, без него jpeg
+func (p *Pipeline) Format() string {
+	format := "jpeg"
+	for _, step := range p.steps {
+		if e, ok := step.(Encode); ok {
+			format = e.Format
+		}
+	}
+	return format
+}
+
+// Run проводит кадр через все шаги. Кроме изображения в f можно заранее задать Focus и Smart
+func (p *Pipeline) Run(ctx context.Context, f *Frame) error {
+	loc := "Pipeline.Run"
+	for _, step := range p.steps {
+		if ctx.Err() != nil {
+			return models.NewError(loc, "context", ctx.Err())
+		}
+		if err := step.Apply(ctx, f); err != nil {
+			return models.NewError(loc, stepName(step), err)
+		}
+	}
+	return nil
+}
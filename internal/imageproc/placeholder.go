@@ -0,0 +1,89 @@
+package imageproc
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/buckket/go-blurhash"
+	"golang.org/x/image/draw"
+)
+
+// заглушка считается по уменьшенной копии: BlurHash все равно хранит только
+// несколько низких частот, а на полном изображении он считается секундами
+const placeholderSize = 64
+
+// Placeholder считает BlurHash и доминирующий цвет текущего изображения, чтобы фронтенд
+// мог показать размытую заглушку, не скачивая файл. Ставится после шагов, меняющих кадр
+// (crop, pad, resize), иначе заглушка не совпадет с тем, что сохранится
+type Placeholder struct {
+	ComponentsX int // 1-9, по горизонтали
+	ComponentsY int // 1-9, по вертикали
+}
+
+func (s Placeholder) Apply(ctx context.Context, f *Frame) error {
+	small := thumbnail(f.Image, placeholderSize)
+	hash, err := blurhash.Encode(s.ComponentsX, s.ComponentsY, small)
+	if err != nil {
+		return err
+	}
+	f.BlurHash = hash
+	f.DominantColor = DominantColor(small)
+	return ctx.Err()
+}
+
+// DominantColor - самый частый цвет изображения в виде "#rrggbb". Цвета группируются
+// по 4 старшим битам каждого канала, результат - среднее самой большой группы,
+// почти прозрачные пиксели не учитываются
+func DominantColor(img image.Image) string {
+	c, ok := dominantRGB(img)
+	if !ok {
+		return "#ffffff" // полностью прозрачное изображение сохранится белым
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2])
+}
+
+// dominantRGB - среднее самой большой группы цветов, false - непрозрачных пикселей нет
+func dominantRGB(img image.Image) ([3]uint8, bool) {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	var buckets [1 << 12]bucket
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			bk := &buckets[int(c.R>>4)<<8|int(c.G>>4)<<4|int(c.B>>4)]
+			bk.count++
+			bk.r += int(c.R)
+			bk.g += int(c.G)
+			bk.b += int(c.B)
+		}
+	}
+	best := &buckets[0]
+	for i := range buckets {
+		if buckets[i].count > best.count {
+			best = &buckets[i]
+		}
+	}
+	if best.count == 0 {
+		return [3]uint8{}, false
+	}
+	return [3]uint8{uint8(best.r / best.count), uint8(best.g / best.count), uint8(best.b / best.count)}, true
+}
+
+// thumbnail - копия, вписанная в size x size, с началом координат в (0, 0) и без прозрачности:
+// прозрачные области становятся белыми, как и при сохранении в JPEG
+func thumbnail(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	w, h := fitInside(b.Dx(), b.Dy(), size, size)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,103 @@
+package imageproc
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+)
+
+// по какой копии считаются метрики качества: так они не зависят от разрешения загрузки,
+// иначе резкость большой фотографии и маленькой картинки нельзя было бы сравнить
+const qualitySize = 512
+
+// насколько цвет пикселя может отличаться от цвета фона (евклидово расстояние в RGB),
+// чтобы пиксель считался фоном
+const uniformTolerance = 32
+
+// Metrics - метрики качества загрузки
+type Metrics struct {
+	Sharpness  float64 // дисперсия лапласиана яркости, у размытых снимков мала
+	Brightness float64 // средняя яркость, 0-255
+	Uniformity float64 // доля пикселей цвета фона, 0-1: близко к 1 - пустой кадр или крошечный товар
+}
+
+// Measure считает метрики по копии не больше qualitySize. Прозрачные области считаются белыми
+func Measure(ctx context.Context, img image.Image) (Metrics, error) {
+	small := thumbnail(img, qualitySize)
+	gray, err := ToGray(ctx, small)
+	if err != nil {
+		return Metrics{}, err
+	}
+	b := gray.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return Metrics{}, nil
+	}
+
+	var m Metrics
+	var sum float64
+	for y := 0; y < h; y++ {
+		for _, v := range gray.Pix[y*gray.Stride : y*gray.Stride+w] {
+			sum += float64(v)
+		}
+	}
+	m.Brightness = sum / float64(w*h)
+
+	// лапласиан 4-соседей по внутренним пикселям
+	if w > 2 && h > 2 {
+		var s, sq float64
+		for y := 1; y < h-1; y++ {
+			row := gray.Pix[y*gray.Stride:]
+			up, down := gray.Pix[(y-1)*gray.Stride:], gray.Pix[(y+1)*gray.Stride:]
+			for x := 1; x < w-1; x++ {
+				l := float64(up[x]) + float64(down[x]) + float64(row[x-1]) + float64(row[x+1]) - 4*float64(row[x])
+				s += l
+				sq += l * l
+			}
+		}
+		n := float64((w - 2) * (h - 2))
+		m.Sharpness = sq/n - (s/n)*(s/n)
+	}
+	if err := ctx.Err(); err != nil {
+		return Metrics{}, err
+	}
+
+	bg, _ := dominantRGB(small)
+	var same int
+	for y := 0; y < h; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < w; x++ {
+			dr := float64(row[x*4]) - float64(bg[0])
+			dg := float64(row[x*4+1]) - float64(bg[1])
+			db := float64(row[x*4+2]) - float64(bg[2])
+			if math.Sqrt(dr*dr+dg*dg+db*db) <= uniformTolerance {
+				same++
+			}
+		}
+	}
+	m.Uniformity = float64(same) / float64(w*h)
+	return m, nil
+}
+
+// QualityLimits - пороги метрик, 0 - порог не проверяется
+type QualityLimits struct {
+	MinSharpness  float64
+	MinBrightness float64
+	MaxUniformity float64
+}
+
+// Check возвращает по причине на каждый нарушенный порог, пусто - загрузка в порядке
+func (l QualityLimits) Check(m Metrics) []string {
+	var reasons []string
+	if l.MinSharpness > 0 && m.Sharpness < l.MinSharpness {
+		reasons = append(reasons, fmt.Sprintf("blurry (sharpness %.0f < %.0f)", m.Sharpness, l.MinSharpness))
+	}
+	if l.MinBrightness > 0 && m.Brightness < l.MinBrightness {
+		reasons = append(reasons, fmt.Sprintf("too dark (brightness %.0f < %.0f)", m.Brightness, l.MinBrightness))
+	}
+	if l.MaxUniformity > 0 && m.Uniformity > l.MaxUniformity {
+		reasons = append(reasons, fmt.Sprintf("almost uniform (%.1f%% of pixels are one colour)", m.Uniformity*100))
+	}
+	return reasons
+}
@@ -0,0 +1,109 @@
+package imageproc
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// по какой копии ищется окно обрезки: точности в пару процентов для выбора окна достаточно
+const saliencySize = 128
+
+// cropOrigin - левый верхний угол окна cw x ch внутри b: по точке интереса, если она есть,
+// иначе при smart - по деталям изображения, иначе по центру
+func cropOrigin(ctx context.Context, img image.Image, cw, ch int, focus *models.FocalPoint, smart bool) (image.Point, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	origin := image.Pt((w-cw)/2, (h-ch)/2)
+	switch {
+	case focus != nil:
+		origin.X = clampInt(int(math.Round(focus.X*float64(w)-float64(cw)/2)), 0, w-cw)
+		origin.Y = clampInt(int(math.Round(focus.Y*float64(h)-float64(ch)/2)), 0, h-ch)
+	case smart:
+		var err error
+		if origin, err = salientOrigin(ctx, img, cw, ch); err != nil {
+			return image.Point{}, err
+		}
+	}
+	return origin.Add(b.Min), nil
+}
+
+// salientOrigin двигает окно cw x ch вдоль оси, по которой оно меньше изображения, и выбирает
+// положение, где больше всего деталей. Детали - модуль градиента яркости уменьшенной копии:
+// у товара на однотонном фоне они сосредоточены на самом товаре. При равенстве выбирается
+// положение ближе к центру, так что у однотонных изображений обрезка остается центральной
+func salientOrigin(ctx context.Context, img image.Image, cw, ch int) (image.Point, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	center := image.Pt((w-cw)/2, (h-ch)/2)
+	if cw >= w && ch >= h {
+		return center, nil
+	}
+	gray, err := ToGray(ctx, thumbnail(img, saliencySize))
+	if err != nil {
+		return image.Point{}, err
+	}
+	sw, sh := gray.Bounds().Dx(), gray.Bounds().Dy()
+	horizontal := cw < w
+
+	// энергия по столбцам (окно едет по горизонтали) или по строкам
+	n := sh
+	if horizontal {
+		n = sw
+	}
+	energy := make([]int, n)
+	for y := 0; y < sh; y++ {
+		row := gray.Pix[y*gray.Stride:]
+		for x := 0; x < sw; x++ {
+			var e int
+			if x+1 < sw {
+				e += absInt(int(row[x+1]) - int(row[x]))
+			}
+			if y+1 < sh {
+				e += absInt(int(gray.Pix[(y+1)*gray.Stride+x]) - int(row[x]))
+			}
+			if horizontal {
+				energy[x] += e
+			} else {
+				energy[y] += e
+			}
+		}
+	}
+
+	// размер окна в координатах копии
+	full, window := h, ch
+	if horizontal {
+		full, window = w, cw
+	}
+	size := clampInt(int(math.Round(float64(window)*float64(n)/float64(full))), 1, n)
+	prefix := make([]int, n+1)
+	for i, e := range energy {
+		prefix[i+1] = prefix[i] + e
+	}
+	mid := float64(n-size) / 2
+	best, bestSum := 0, -1
+	for start := 0; start+size <= n; start++ {
+		sum := prefix[start+size] - prefix[start]
+		if sum > bestSum || sum == bestSum && math.Abs(float64(start)-mid) < math.Abs(float64(best)-mid) {
+			best, bestSum = start, sum
+		}
+	}
+	offset := clampInt(int(math.Round(float64(best)*float64(full)/float64(n))), 0, full-window)
+	if horizontal {
+		return image.Pt(offset, center.Y), nil
+	}
+	return image.Pt(center.X, offset), nil
+}
+
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,243 @@
+package imageproc
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+	"golang.org/x/image/draw"
+)
+
+// Resize вписывает изображение в рамку Width x Height с сохранением пропорций.
+// Нулевая сторона не ограничивается, увеличения не бывает
+type Resize struct {
+	Width  int
+	Height int
+}
+
+func (s Resize) Apply(ctx context.Context, f *Frame) error {
+	b := f.Image.Bounds()
+	w, h := fitInside(b.Dx(), b.Dy(), s.Width, s.Height)
+	if w == b.Dx() && h == b.Dy() {
+		return nil
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), f.Image, b, draw.Src, nil)
+	f.Image = dst
+	return ctx.Err()
+}
+
+// Crop обрезает изображение до соотношения сторон Ratio (ширина / высота).
+// Окно ставится по точке интереса кадра, у обложек без нее - по деталям изображения,
+// в остальных случаях - по центру
+type Crop struct {
+	Ratio float64
+}
+
+func (s Crop) Apply(ctx context.Context, f *Frame) error {
+	b := f.Image.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cw, ch := w, h
+	if float64(w)/float64(h) > s.Ratio {
+		cw = max(1, int(math.Round(float64(h)*s.Ratio)))
+	} else {
+		ch = max(1, int(math.Round(float64(w)/s.Ratio)))
+	}
+	if cw == w && ch == h {
+		return nil
+	}
+	origin, err := cropOrigin(ctx, f.Image, cw, ch, f.Focus, f.Smart)
+	if err != nil {
+		return err
+	}
+	if f.Focus != nil {
+		// точка остается той же точкой изображения, но в долях нового кадра
+		f.Focus = &models.FocalPoint{
+			X: min(max((f.Focus.X*float64(w)-float64(origin.X-b.Min.X))/float64(cw), 0), 1),
+			Y: min(max((f.Focus.Y*float64(h)-float64(origin.Y-b.Min.Y))/float64(ch), 0), 1),
+		}
+	}
+	f.Image = subImage(f.Image, image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cw, ch))})
+	return ctx.Err()
+}
+
+// Pad дополняет изображение полями цвета Color до соотношения сторон Ratio,
+// исходное изображение остается по центру. При Edge цвет полей - средний цвет
+// тех краев изображения, к которым поля примыкают, чтобы поля сливались с фоном
+type Pad struct {
+	Ratio float64
+	Color color.Color
+	Edge  bool
+}
+
+func (s Pad) Apply(ctx context.Context, f *Frame) error {
+	b := f.Image.Bounds()
+	w, h := b.Dx(), b.Dy()
+	pw, ph := w, h
+	if float64(w)/float64(h) < s.Ratio {
+		pw = int(math.Round(float64(h) * s.Ratio))
+	} else {
+		ph = int(math.Round(float64(w) / s.Ratio))
+	}
+	if pw == w && ph == h {
+		return nil
+	}
+	fill := s.Color
+	if s.Edge {
+		fill = EdgeColor(f.Image, pw > w)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, pw, ph))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+	offset := image.Pt((pw-w)/2, (ph-h)/2)
+	draw.Draw(dst, image.Rectangle{Min: offset, Max: offset.Add(b.Size())}, f.Image, b.Min, draw.Over)
+	if f.Focus != nil {
+		f.Focus = &models.FocalPoint{
+			X: (f.Focus.X*float64(w) + float64(offset.X)) / float64(pw),
+			Y: (f.Focus.Y*float64(h) + float64(offset.Y)) / float64(ph),
+		}
+	}
+	f.Image = dst
+	return ctx.Err()
+}
+
+// EdgeColor - средний цвет левого и правого столбцов изображения (vertical) или верхней
+// и нижней строк. Прозрачность учитывается так, будто под изображением белый фон
+func EdgeColor(img image.Image, vertical bool) color.Color {
+	b := img.Bounds()
+	var sum [3]uint64
+	var n uint64
+	add := func(x, y int) {
+		r, g, bl, a := img.At(x, y).RGBA()
+		// premultiplied поверх белого
+		sum[0] += uint64(r + 0xffff - a)
+		sum[1] += uint64(g + 0xffff - a)
+		sum[2] += uint64(bl + 0xffff - a)
+		n++
+	}
+	if vertical {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			add(b.Min.X, y)
+			add(b.Max.X-1, y)
+		}
+	} else {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			add(x, b.Min.Y)
+			add(x, b.Max.Y-1)
+		}
+	}
+	return color.RGBA64{R: uint16(sum[0] / n), G: uint16(sum[1] / n), B: uint16(sum[2] / n), A: 0xffff}
+}
+
+// Grayscale переводит изображение в оттенки серого
+type Grayscale struct{}
+
+func (Grayscale) Apply(ctx context.Context, f *Frame) error {
+	img, err := ToGray(ctx, f.Image)
+	if err != nil {
+		return err
+	}
+	f.Image = img
+	return nil
+}
+
+// Sharpen - нерезкое маскирование: к пикселю добавляется его отличие
+// от размытия 3x3, умноженное на Amount
+type Sharpen struct {
+	Amount float64
+}
+
+func (s Sharpen) Apply(ctx context.Context, f *Frame) error {
+	src := toRGBA(f.Image)
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	w, h := b.Dx(), b.Dy()
+
+	err := parallelRows(ctx, h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				var sum [3]int
+				for dy := -1; dy <= 1; dy++ {
+					yy := min(max(y+dy, 0), h-1)
+					for dx := -1; dx <= 1; dx++ {
+						xx := min(max(x+dx, 0), w-1)
+						i := yy*src.Stride + xx*4
+						sum[0] += int(src.Pix[i])
+						sum[1] += int(src.Pix[i+1])
+						sum[2] += int(src.Pix[i+2])
+					}
+				}
+				i := y*src.Stride + x*4
+				for c := 0; c < 3; c++ {
+					orig := float64(src.Pix[i+c])
+					v := orig + s.Amount*(orig-float64(sum[c])/9)
+					// в premultiplied RGBA канал не может быть больше альфы
+					dst.Pix[i+c] = uint8(min(max(math.Round(v), 0), float64(src.Pix[i+3])))
+				}
+				dst.Pix[i+3] = src.Pix[i+3]
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	f.Image = dst
+	return nil
+}
+
+// Encode задает формат и качество, с которыми хранилище сохранит результат,
+// бюджет на размер файла и форматы запасных копий (например, jpeg при основном webp)
+type Encode struct {
+	Format     string
+	Quality    int
+	MaxBytes   int
+	MinQuality int
+	Fallback   []string
+}
+
+func (s Encode) Apply(ctx context.Context, f *Frame) error {
+	f.Encode = models.EncodeOptions{Format: s.Format, Quality: s.Quality, MaxBytes: s.MaxBytes, MinQuality: s.MinQuality}
+	f.Fallback = s.Fallback
+	return nil
+}
+
+func stepName(step Step) string {
+	return fmt.Sprintf("%T", step)
+}
+
+// fitInside возвращает размеры w x h, вписанные в maxW x maxH без увеличения
+func fitInside(w, h, maxW, maxH int) (int, int) {
+	scale := 1.0
+	if maxW > 0 && w > maxW {
+		scale = float64(maxW) / float64(w)
+	}
+	if maxH > 0 && h > maxH {
+		scale = min(scale, float64(maxH)/float64(h))
+	}
+	if scale == 1.0 {
+		return w, h
+	}
+	return max(1, int(math.Round(float64(w)*scale))), max(1, int(math.Round(float64(h)*scale)))
+}
+
+// subImage вырезает r без копирования, если тип изображения это позволяет
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if si, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return si.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// toRGBA возвращает копию изображения в *image.RGBA с началом координат в (0, 0)
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,256 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// StripMetadata возвращает копию сырых байт без EXIF (в том числе GPS), XMP, IPTC и комментариев.
+// Профиль ICC и то, что нужно декодеру, остаются. Вместе с EXIF уходит и Orientation:
+// Decode копии ее не развернет, поворот надо применить отдельно (Orient).
+// BMP метаданных не несет и возвращается как есть. У TIFF метаданные - сама структура файла,
+// для него errors.ErrUnsupported
+func StripMetadata(data []byte) ([]byte, error) {
+	var (
+		out []byte
+		err error
+	)
+	switch format := Sniff(data); format {
+	case "jpeg":
+		out, err = stripJPEG(data)
+	case "png":
+		out, err = stripPNG(data)
+	case "webp":
+		out, err = stripWebP(data)
+	case "gif":
+		out, err = stripGIF(data)
+	case "bmp":
+		return data, nil
+	default:
+		return nil, fmt.Errorf("strip metadata from %q: %w", format, errors.ErrUnsupported)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("strip metadata: %w", err)
+	}
+	return out, nil
+}
+
+var errMalformed = errors.New("malformed file structure")
+
+// stripJPEG оставляет APP0 (JFIF), APP2 с профилем ICC, APP14 (Adobe, по нему декодер
+// узнает CMYK) и все сегменты без метаданных. Остальные APPn (EXIF, XMP, MPF, IPTC)
+// и комментарии отбрасываются, как и все, что лежит после EOI, - например, вложенные
+// копии MPF со своим EXIF
+func stripJPEG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, jpegSOI...)
+
+	data = data[len(jpegSOI):]
+	for {
+		if len(data) < 2 || data[0] != 0xff {
+			return nil, errMalformed
+		}
+		marker := data[1]
+		switch {
+		case marker == 0xff: // заполняющий байт
+			data = data[1:]
+			continue
+		case marker == 0xd9: // EOI
+			return append(out, 0xff, 0xd9), nil
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7: // маркеры без длины
+			out = append(out, data[:2]...)
+			data = data[2:]
+			continue
+		}
+		if len(data) < 4 {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint16(data[2:4]))
+		if n < 2 || len(data) < 2+n {
+			return nil, errMalformed
+		}
+		segment, payload := data[:2+n], data[4:2+n]
+		data = data[2+n:]
+
+		keep := true
+		switch {
+		case marker == 0xe0:
+		case marker == 0xe2:
+			keep = bytes.HasPrefix(payload, jpegICCHeader)
+		case marker == 0xee:
+		case marker >= 0xe1 && marker <= 0xef, marker == 0xfe:
+			keep = false
+		}
+		if keep {
+			out = append(out, segment...)
+		}
+		if marker != 0xda {
+			continue
+		}
+		// данные скана: 0xff внутри них идет только с 0x00 или маркером RST
+		i := 0
+		for i+1 < len(data) && (data[i] != 0xff || data[i+1] == 0x00 || data[i+1] >= 0xd0 && data[i+1] <= 0xd7 || data[i+1] == 0xff) {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, errMalformed
+		}
+		out = append(out, data[:i]...)
+		data = data[i:]
+	}
+}
+
+// pngKeep - чанки, которые нужны для пикселей и цвета. Текстовые чанки (в iTXt лежит XMP),
+// eXIf, tIME и все неизвестные отбрасываются
+var pngKeep = map[string]bool{
+	"IHDR": true, "PLTE": true, "IDAT": true, "IEND": true, "tRNS": true, "cHRM": true, "gAMA": true,
+	"iCCP": true, "sBIT": true, "sRGB": true, "cICP": true, "bKGD": true, "pHYs": true,
+	"acTL": true, "fcTL": true, "fdAT": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngMagic...)
+
+	data = data[len(pngMagic):]
+	for {
+		if len(data) < 12 {
+			return nil, errMalformed
+		}
+		n := binary.BigEndian.Uint32(data[:4])
+		if uint64(n)+12 > uint64(len(data)) {
+			return nil, errMalformed
+		}
+		typ := string(data[4:8])
+		chunk := data[:12+n]
+		data = data[12+n:]
+		if pngKeep[typ] {
+			out = append(out, chunk...)
+		}
+		if typ == "IEND" {
+			return out, nil
+		}
+	}
+}
+
+// webpKeep - чанки изображения, анимации и профиля ICC. EXIF, XMP и неизвестные отбрасываются
+var webpKeep = map[string]bool{
+	"VP8 ": true, "VP8L": true, "VP8X": true, "ALPH": true, "ANIM": true, "ANMF": true, "ICCP": true,
+}
+
+// флаги в первом байте чанка VP8X
+const (
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	size := uint64(binary.LittleEndian.Uint32(data[4:8]))
+	if size < 4 || size+8 > uint64(len(data)) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+
+	data = data[12 : 8+size]
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errMalformed
+		}
+		n := uint64(binary.LittleEndian.Uint32(data[4:8]))
+		if n+8 > uint64(len(data)) {
+			return nil, errMalformed
+		}
+		typ := string(data[:4])
+		end := min(8+n+n%2, uint64(len(data))) // чанки выровнены по 2 байтам
+		chunk := data[:end]
+		data = data[end:]
+		if !webpKeep[typ] {
+			continue
+		}
+		start := len(out)
+		out = append(out, chunk...)
+		if typ == "VP8X" {
+			if n < 1 {
+				return nil, errMalformed
+			}
+			out[start+8] &^= webpFlagXMP | webpFlagEXIF
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// stripGIF убирает комментарии, простой текст и расширения приложений (в них лежит XMP),
+// кроме NETSCAPE2.0 и ANIMEXTS1.0 с числом повторов анимации
+func stripGIF(data []byte) ([]byte, error) {
+	const header = 13 // сигнатура, версия и дескриптор экрана
+	if len(data) < header {
+		return nil, errMalformed
+	}
+	pos := header
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1) // глобальная палитра
+	}
+	if pos > len(data) {
+		return nil, errMalformed
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:pos]...)
+
+	// subBlocks возвращает позицию после цепочки подблоков, начинающейся с i
+	subBlocks := func(i int) (int, error) {
+		for i < len(data) {
+			n := int(data[i])
+			i += 1 + n
+			if n == 0 {
+				return i, nil
+			}
+		}
+		return 0, errMalformed
+	}
+	for pos < len(data) {
+		start := pos
+		switch data[pos] {
+		case 0x3b: // конец файла
+			return append(out, 0x3b), nil
+		case 0x2c: // кадр: дескриптор, локальная палитра, размер кода LZW и данные
+			if pos+10 > len(data) {
+				return nil, errMalformed
+			}
+			pos += 10
+			if data[start+9]&0x80 != 0 {
+				pos += 3 << (data[start+9]&0x07 + 1)
+			}
+			end, err := subBlocks(pos + 1)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[start:end]...)
+			pos = end
+		case 0x21: // расширение: метка и подблоки
+			if pos+2 > len(data) {
+				return nil, errMalformed
+			}
+			label := data[pos+1]
+			end, err := subBlocks(pos + 2)
+			if err != nil {
+				return nil, err
+			}
+			keep := label == 0xf9 // управление графикой: задержка и прозрачность
+			if label == 0xff && pos+14 <= len(data) && data[pos+2] == 11 {
+				app := string(data[pos+3 : pos+14])
+				keep = app == "NETSCAPE2.0" || app == "ANIMEXTS1.0"
+			}
+			if keep {
+				out = append(out, data[start:end]...)
+			}
+			pos = end
+		default:
+			return nil, errMalformed
+		}
+	}
+	return nil, errMalformed
+}
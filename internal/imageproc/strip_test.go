@@ -0,0 +1,231 @@
+package imageproc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// строки, которых не должно остаться в очищенном файле
+var secrets = []string{"GPS-SECRET", "XMP-SECRET", "IPTC-SECRET", "COMMENT-SECRET", "TRAILER-SECRET"}
+
+var fakeICC = append([]byte("fake profile "), bytes.Repeat([]byte{0xab}, 200)...)
+
+func testImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 24, 16))
+	for y := range 16 {
+		for x := range 24 {
+			img.Set(x, y, color.NRGBA{uint8(x * 10), uint8(y * 15), 80, 255})
+		}
+	}
+	return img
+}
+
+// exifTIFF - TIFF блок с Orientation и GPS IFD, в котором лежит строка GPS-SECRET
+func exifTIFF(orientation int) []byte {
+	le := binary.LittleEndian
+	b := []byte("II\x2a\x00\x08\x00\x00\x00")
+	b = le.AppendUint16(b, 2)
+	b = append(b, le.AppendUint16(le.AppendUint16(nil, exifOrientationTag), 3)...)
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint32(b, uint32(orientation))
+	b = append(b, le.AppendUint16(le.AppendUint16(nil, 0x8825), 4)...) // GPSInfo, LONG
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint32(b, 38) // смещение GPS IFD сразу после IFD0
+	b = le.AppendUint32(b, 0)
+	// GPS IFD: GPSLatitudeRef (ASCII) и GPSProcessingMethod со строкой
+	b = le.AppendUint16(b, 1)
+	b = append(b, le.AppendUint16(le.AppendUint16(nil, 0x001b), 2)...)
+	b = le.AppendUint32(b, 11)
+	b = le.AppendUint32(b, 56)
+	b = le.AppendUint32(b, 0)
+	return append(b, "GPS-SECRET\x00"...)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	b := []byte{0xff, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+// jpegWithMetadata кодирует testImage и вставляет после SOI EXIF с GPS, XMP, IPTC,
+// профиль ICC и комментарий, а после EOI - мусор, как у вложенных копий MPF
+func jpegWithMetadata(t *testing.T, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	var b []byte
+	b = append(b, jpegSOI...)
+	b = append(b, jpegSegment(0xe1, append(bytes.Clone(exifHeader), exifTIFF(orientation)...))...)
+	b = append(b, jpegSegment(0xe1, []byte("http://ns.adobe.com/xap/1.0/\x00<x>XMP-SECRET</x>"))...)
+	b = append(b, jpegSegment(0xed, []byte("Photoshop 3.0\x00IPTC-SECRET"))...)
+	b = append(b, jpegSegment(0xe2, append(append(bytes.Clone(jpegICCHeader), 1, 1), fakeICC...))...)
+	b = append(b, jpegSegment(0xfe, []byte("COMMENT-SECRET"))...)
+	b = append(b, data[len(jpegSOI):]...)
+	return append(b, "TRAILER-SECRET"...)
+}
+
+func pngChunk(typ string, body []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	b = append(b, typ...)
+	b = append(b, body...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+// pngWithMetadata вставляет eXIf, iTXt с XMP и tEXt перед данными изображения
+func pngWithMetadata(t *testing.T, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	idat := bytes.Index(data, []byte("IDAT")) - 4
+	var b []byte
+	b = append(b, data[:idat]...)
+	b = append(b, pngChunk("eXIf", exifTIFF(orientation))...)
+	b = append(b, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00XMP-SECRET"))...)
+	b = append(b, pngChunk("tEXt", []byte("Comment\x00COMMENT-SECRET"))...)
+	return append(b, data[idat:]...)
+}
+
+func webpChunk(typ string, body []byte) []byte {
+	b := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// webpWithMetadata собирает расширенный WebP: VP8X с флагами, ICCP, VP8L, EXIF и XMP
+func webpWithMetadata(t *testing.T, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	vp8l := buf.Bytes()[12:] // чанк VP8L простого файла
+	vp8x := []byte{0x20 | webpFlagEXIF | webpFlagXMP, 0, 0, 0, 23, 0, 0, 15, 0, 0}
+	var body []byte
+	body = append(body, "WEBP"...)
+	body = append(body, webpChunk("VP8X", vp8x)...)
+	body = append(body, webpChunk("ICCP", fakeICC)...)
+	body = append(body, vp8l...)
+	body = append(body, webpChunk("EXIF", exifTIFF(orientation))...)
+	body = append(body, webpChunk("XMP ", []byte("<x>XMP-SECRET</x>"))...)
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+// gifWithMetadata вставляет после заголовка комментарий и расширение приложения с XMP
+func gifWithMetadata(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+	var b []byte
+	b = append(b, data[:pos]...)
+	b = append(b, 0x21, 0xfe, 14)
+	b = append(b, "COMMENT-SECRET\x00"...)
+	b = append(b, 0x21, 0xff, 11)
+	b = append(b, "XMP DataXMP"...)
+	b = append(b, 10)
+	b = append(b, "XMP-SECRET\x00"...)
+	return append(b, data[pos:]...)
+}
+
+func TestStripMetadata(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		icc  bool
+	}{
+		{"jpeg", jpegWithMetadata(t, OrientationRotate90), true},
+		{"png", pngWithMetadata(t, OrientationRotate90), false},
+		{"webp", webpWithMetadata(t, OrientationRotate90), true},
+		{"gif", gifWithMetadata(t), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.name != "gif" && ExifOrientation(tc.data) != OrientationRotate90 {
+				t.Fatal("fixture has no orientation")
+			}
+			before, err := Decode(context.Background(), tc.data, nil, Limits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := StripMetadata(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range secrets {
+				if bytes.Contains(out, []byte(s)) {
+					t.Errorf("%s left in the file", s)
+				}
+			}
+			if o := ExifOrientation(out); o != OrientationUnknown {
+				t.Errorf("orientation %d left in the file", o)
+			}
+			if got := ICCProfile(out); tc.icc && !bytes.Equal(got, fakeICC) {
+				t.Errorf("ICC profile lost: %q", got)
+			}
+
+			// без тега копия декодируется неповернутой, Orient возвращает ее к исходнику
+			after, err := Decode(context.Background(), out, nil, Limits{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := Orient(context.Background(), after.Image, before.Orientation)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Format != before.Format || img.Bounds().Size() != before.Image.Bounds().Size() {
+				t.Fatalf("decoded %s %v, want %s %v", after.Format, img.Bounds(), before.Format, before.Image.Bounds())
+			}
+			b := img.Bounds()
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					bb := before.Image.Bounds()
+					if color.NRGBAModel.Convert(img.At(x, y)) != color.NRGBAModel.Convert(before.Image.At(bb.Min.X+x-b.Min.X, bb.Min.Y+y-b.Min.Y)) {
+						t.Fatalf("pixel (%d, %d) differs", x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStripMetadataTIFF(t *testing.T) {
+	_, err := StripMetadata([]byte("II*\x00\x08\x00\x00\x00"))
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Fatalf("err = %v, want errors.ErrUnsupported", err)
+	}
+}
+
+func TestStripMetadataMalformed(t *testing.T) {
+	data := jpegWithMetadata(t, OrientationNormal)
+	// обрыв до конца данных скана
+	if _, err := StripMetadata(data[:len(data)/2]); err == nil {
+		t.Fatal("truncated jpeg: want error")
+	}
+}
@@ -0,0 +1,68 @@
+package imageproc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// Variant - производный размер изображения (миниатюра для списка, карточка, зум)
+type Variant struct {
+	Name    string
+	Width   int
+	Height  int
+	Cover   bool // true - заполнить рамку целиком с обрезкой, как у шага Crop, false - вписать в рамку
+	Quality int
+	// бюджет на размер файла, 0 - как у основного изображения
+	MaxBytes   int
+	MinQuality int
+}
+
+// Render строит вариант из результата конвейера, не изменяя его. Обрезка учитывает
+// точку интереса и Smart кадра
+func (v Variant) Render(ctx context.Context, frame *Frame) (image.Image, error) {
+	f := &Frame{Image: frame.Image, Focus: frame.Focus, Smart: frame.Smart}
+	if v.Cover {
+		if err := (Crop{Ratio: float64(v.Width) / float64(v.Height)}).Apply(ctx, f); err != nil {
+			return nil, err
+		}
+	}
+	if err := (Resize{Width: v.Width, Height: v.Height}).Apply(ctx, f); err != nil {
+		return nil, err
+	}
+	return f.Image, nil
+}
+
+func VariantsFromConfig(cfg []config.Variant) ([]Variant, error) {
+	loc := "imageproc.VariantsFromConfig"
+	variants := make([]Variant, 0, len(cfg))
+	seen := make(map[string]bool, len(cfg))
+	for _, vc := range cfg {
+		if seen[vc.Name] {
+			return nil, models.NewError(loc, vc.Name, errors.New("duplicate variant name"))
+		}
+		seen[vc.Name] = true
+		// запасные копии в другом формате хранятся как варианты с именем формата
+		if slices.Contains(OutputFormats, vc.Name) {
+			return nil, models.NewError(loc, vc.Name, errors.New("variant name is reserved for format fallbacks"))
+		}
+		if vc.Width == 0 && vc.Height == 0 {
+			return nil, models.NewError(loc, vc.Name, errors.New("width or height is required"))
+		}
+		cover := vc.Fit == "cover"
+		if cover && (vc.Width == 0 || vc.Height == 0) {
+			return nil, models.NewError(loc, vc.Name, fmt.Errorf("fit %q needs both width and height", vc.Fit))
+		}
+		if vc.Quality > 0 && vc.MinQuality > vc.Quality {
+			return nil, models.NewError(loc, vc.Name, fmt.Errorf("min_quality %d is greater than quality %d", vc.MinQuality, vc.Quality))
+		}
+		variants = append(variants, Variant{Name: vc.Name, Width: vc.Width, Height: vc.Height, Cover: cover, Quality: vc.Quality,
+			MaxBytes: vc.MaxBytes, MinQuality: vc.MinQuality})
+	}
+	return variants, nil
+}
@@ -0,0 +1,79 @@
+package imageproc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+
+	"golang.org/x/image/draw"
+)
+
+// Watermark накладывает знак (PNG с альфа-каналом) на кадр. Знак рисуется на новой копии:
+// входное изображение не меняется, поэтому временный оригинал, который перечитывается
+// при повторной обработке, остается чистым и знак не ложится дважды
+type Watermark struct {
+	Mark     image.Image
+	Position string  // top-left, top-right, bottom-left, bottom-right, center
+	Scale    float64 // ширина знака в долях ширины кадра
+	Opacity  float64 // 0-1, умножается на собственную альфу знака
+	Margin   float64 // отступ от краев в долях меньшей стороны кадра
+}
+
+func (s Watermark) Apply(ctx context.Context, f *Frame) error {
+	b := f.Image.Bounds()
+	mb := s.Mark.Bounds()
+	mw := max(1, int(math.Round(s.Scale*float64(b.Dx()))))
+	mh := max(1, int(math.Round(float64(mw)*float64(mb.Dy())/float64(mb.Dx()))))
+	mark := image.NewRGBA(image.Rect(0, 0, mw, mh))
+	draw.CatmullRom.Scale(mark, mark.Bounds(), s.Mark, mb, draw.Src, nil)
+
+	dst := toRGBA(f.Image)
+	margin := int(math.Round(s.Margin * float64(min(b.Dx(), b.Dy()))))
+	at := watermarkOrigin(dst.Bounds(), mark.Bounds().Size(), s.Position, margin)
+	opacity := image.NewUniform(color.Alpha{A: uint8(math.Round(s.Opacity * 0xff))})
+	draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(mark.Bounds().Size())}, mark, image.Point{}, opacity, image.Point{}, draw.Over)
+	f.Image = dst
+	return ctx.Err()
+}
+
+func watermarkOrigin(b image.Rectangle, size image.Point, position string, margin int) image.Point {
+	left, top := b.Min.X+margin, b.Min.Y+margin
+	right, bottom := b.Max.X-margin-size.X, b.Max.Y-margin-size.Y
+	switch position {
+	case "top-left":
+		return image.Pt(left, top)
+	case "top-right":
+		return image.Pt(right, top)
+	case "bottom-left":
+		return image.Pt(left, bottom)
+	case "center":
+		return image.Pt(b.Min.X+(b.Dx()-size.X)/2, b.Min.Y+(b.Dy()-size.Y)/2)
+	}
+	return image.Pt(right, bottom)
+}
+
+// LoadWatermark читает знак из PNG. Знак без прозрачности закрыл бы прямоугольник кадра,
+// поэтому такой файл считается ошибкой настройки
+func LoadWatermark(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	mark, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("watermark %s: %w", path, err)
+	}
+	if mark.Bounds().Empty() {
+		return nil, fmt.Errorf("watermark %s is empty", path)
+	}
+	if o, ok := mark.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return nil, errors.New("watermark " + path + " has no transparency")
+	}
+	return mark, nil
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 const (
@@ -30,6 +31,9 @@ var (
 	ErrFS           = errors.New("file system misbehaved")
 	//ErrDoNotRetry      = errors.New("do not retry")
 	ErrUniqueViolation = errors.New("unique violation")
+	ErrShuttingDown    = errors.New("service is shutting down")
+	ErrDuplicate       = errors.New("duplicate image")
+	ErrLowQuality      = errors.New("low quality image")
 )
 
 type Error struct {
@@ -49,3 +53,29 @@ func (e Error) Error() string {
 func (e Error) Unwrap() error {
 	return e.Err
 }
+
+// DuplicateError - изображение похоже на уже загруженное изображение той же сущности
+type DuplicateError struct {
+	Of string // путь сохраненного изображения или id загружаемого в том же стриме
+}
+
+func (e *DuplicateError) Error() string {
+	return "duplicate of " + e.Of
+}
+
+func (e *DuplicateError) Unwrap() error {
+	return ErrDuplicate
+}
+
+// LowQualityError - загрузка не прошла пороги качества сервиса
+type LowQualityError struct {
+	Reasons []string // "blurry (sharpness 12 < 50)", "too dark (...)"
+}
+
+func (e *LowQualityError) Error() string {
+	return "low quality: " + strings.Join(e.Reasons, ", ")
+}
+
+func (e *LowQualityError) Unwrap() error {
+	return ErrLowQuality
+}
@@ -8,6 +8,14 @@ type ProcessImageMessage struct {
 	ImageID      string `json:"image_id"`
 	IsCover      bool   `json:"is_cover"`
 	TmpImagePath string `json:"image_path"`
+	Orientation  int    `json:"orientation"` // EXIF Orientation загрузки
+	// какой поворот применить к временному файлу после чтения: EXIF из него удален вместе с тегом,
+	// 0 - файл уже развернут
+	TmpOrientation int         `json:"tmp_orientation,omitempty"`
+	DuplicateOf    string      `json:"duplicate_of,omitempty"`
+	Focus          *FocalPoint `json:"focus,omitempty"` // точка интереса от клиента для обрезки
+	// преобразование в sRGB при загрузке, временный файл - исходные байты и приводится к sRGB при чтении
+	ColorConversion string `json:"color_conversion,omitempty"`
 }
 
 // gRPC модели ниже
@@ -27,6 +35,14 @@ type DeleteImageRequest struct {
 	Images []string `validate:"required"`
 }
 
+type FindByColorRequest struct {
+	Service  string  `validate:"required"`
+	Color    string  `validate:"required,oneof=black white gray red orange yellow green teal blue purple pink brown beige"`
+	MinShare float64 `validate:"gte=0,lte=1"`
+	After    string
+	Limit    int `validate:"gte=0,lte=1000"`
+}
+
 /*
 // Это сообщение используется между сервисами,
 // чтобы оин добавили новую запись в таблицу со списком изображений
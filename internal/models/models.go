@@ -11,6 +11,96 @@ type EntityState struct {
 type EntityImage struct {
 	Service   string
 	EntityID  string
-	ImagePath string
+	ImagePath string // ключ хранилища, ссылку для клиента из него строит publicurl.Builder
+	MimeType  string
 	IsCover   bool
+	Width     int
+	Height    int
+	// исходный тег EXIF Orientation (1-8), 0 - тега не было или загружено до его учета
+	Orientation int
+	Quality     int   // итоговое качество JPEG, 0 - без потерь или не записано
+	Size        int64 // размер файла в байтах, 0 - не записан
+	// перцептивный хеш (dHash) исходника после поворота, nil - загружено до его подсчета
+	PHash *uint64
+	// ключ или id похожего изображения той же сущности на момент загрузки, "" - не похоже ни на одно
+	DuplicateOf string
+	// размытая заглушка, пока изображение грузится, "" - не посчитана
+	BlurHash      string
+	DominantColor string // "#rrggbb"
+	// размеры загруженного изображения после поворота, до обрезки и полей конвейера, 0 - не записаны
+	OriginalWidth  int
+	OriginalHeight int
+	// какое преобразование в sRGB применено при загрузке (cmyk, adobe-rgb, display-p3), "" - никакого
+	ColorConversion string
+	// основные цвета по убыванию доли, только при сохранении - при чтении не загружаются
+	Palette  []PaletteColor
+	Variants []ImageVariant
+}
+
+// PaletteColor - один из основных цветов изображения
+type PaletteColor struct {
+	Color string  // "#rrggbb"
+	Name  string  // из фиксированного набора: red, blue, beige...
+	Share float64 // доля пикселей, от 0 до 1
+}
+
+// ImageHash - перцептивный хеш сохраненного изображения
+type ImageHash struct {
+	ImagePath string
+	PHash     uint64
+}
+
+// UploadResult - что InitialSave сообщает клиенту об одном изображении
+type UploadResult struct {
+	ImageID     string
+	DuplicateOf string // при политике flag: ключ или id похожего изображения, "" - не дубликат
+	// при политике warn: нарушенные пороги качества, пусто - в порядке
+	QualityIssues []string
+}
+
+// ImageVariant - производный размер изображения, хранится рядом с основным
+type ImageVariant struct {
+	Name     string
+	Path     string // ключ хранилища, как у основного изображения
+	MimeType string
+	Width    int
+	Height   int
+	Quality  int
+	Size     int64
+}
+
+// FocalPoint - точка интереса, которую клиент передал вместе с изображением,
+// в долях ширины и высоты развернутого по EXIF изображения
+type FocalPoint struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// StoredFile - что хранилище сохранило
+type StoredFile struct {
+	Key      string // путь файла через "/" относительно тома или бакета
+	MimeType string
+	Quality  int   // итоговое качество JPEG, 0 - кодирование без потерь
+	Size     int64 // в байтах
+	Shared   bool  // режим cas: такой файл уже был в хранилище, Save только добавил ссылку на него
+}
+
+// BlobRef - сколько раз сущность сослалась на файл в режиме cas. У временных файлов
+// EntityID - <entityID>/tmp
+type BlobRef struct {
+	Service  string
+	EntityID string
+	Key      string
+	Count    int
+}
+
+// EncodeOptions - как хранилищу кодировать изображение.
+// Нулевые значения означают настройки хранилища по умолчанию
+type EncodeOptions struct {
+	Format  string // jpeg, webp
+	Quality int    // только для jpeg, webp кодируется без потерь
+	// бюджет на размер файла: качество jpeg подбирается не выше Quality и не ниже MinQuality,
+	// 0 - без бюджета
+	MaxBytes   int
+	MinQuality int
 }
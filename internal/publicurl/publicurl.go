@@ -0,0 +1,47 @@
+package publicurl
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+)
+
+// DefaultBase - префикс, под которым файловый сервер раздает том
+const DefaultBase = "/static"
+
+// Builder превращает ключи хранилища в ссылки для клиентов и обратно. Ссылка - база и ключ:
+// по умолчанию относительная /static/<key> файлового сервера, иначе адрес CDN
+// или внешний адрес файлового сервера из fileserver.public_url
+type Builder struct {
+	base string // без "/" в конце
+}
+
+func NewBuilder(base string) Builder {
+	if base == "" {
+		base = DefaultBase
+	}
+	return Builder{base: strings.TrimSuffix(base, "/")}
+}
+
+// URL - ссылка на файл с ключом key, для пустого ключа - пустая строка
+func (b Builder) URL(key string) string {
+	if key == "" {
+		return ""
+	}
+	return b.base + (&url.URL{Path: "/" + key}).EscapedPath()
+}
+
+// Key достает ключ из ссылки, которую выдал URL. Строка без базы считается ключом -
+// его проверит хранилище
+func (b Builder) Key(link string) (string, error) {
+	escaped, ok := strings.CutPrefix(link, b.base+"/")
+	if !ok {
+		return link, nil
+	}
+	key, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", models.NewError("Builder.Key", link, models.ErrInvalidInput)
+	}
+	return key, nil
+}
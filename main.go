@@ -1 +1,210 @@
 package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	amt "github.com/glekoz/online-shop_amt"
+	"github.com/glekoz/online-shop_image/application"
+	"github.com/glekoz/online-shop_image/data/db/repository"
+	"github.com/glekoz/online-shop_image/data/storage"
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/health"
+	"github.com/glekoz/online-shop_image/internal/publicurl"
+	imageamt "github.com/glekoz/online-shop_image/presentation/amt"
+	"github.com/glekoz/online-shop_image/presentation/fileserver"
+	imagegrpc "github.com/glekoz/online-shop_image/presentation/grpc"
+	"golang.org/x/sync/errgroup"
+)
+
+func main() {
+	configFile := flag.String("config", os.Getenv(config.EnvPrefix+"_CONFIG"), "path to config file (yaml, json, toml)")
+	flag.Parse()
+
+	if err := run(*configFile); err != nil {
+		slog.Error("image service stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(configFile string) error {
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return err
+	}
+
+	services, err := application.NewServices(cfg.Services)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	repo, err := repository.NewRepository(ctx, cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer repo.Close() // пул закрывается последним
+
+	st, err := newStorage(ctx, cfg.Storage, repo)
+	if err != nil {
+		return err
+	}
+
+	conn, err := amt.Dial(cfg.AMT.DSN)
+	if err != nil {
+		return err
+	}
+	// обработчику нужно приложение, а приложению - очередь из Flow,
+	// поэтому приложение в обработчик подставляется после создания Flow
+	amtHandler := imageamt.NewAMTHandler(nil)
+	flow, err := amt.NewFlow(amtHandler, conn, cfg.AMT.Queue, cfg.AMT.Prefetch)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	app := application.NewApp(repo, st, flow.MQ, services)
+	amtHandler.App = app
+	consumer := imageamt.NewConsumer(flow)
+
+	checker := health.NewChecker(cfg.Health.CheckTimeout, map[string]health.Check{
+		"postgres": repo.Ping,
+		"storage":  st.Check,
+		"amt":      consumer.Check,
+	})
+	imageServer := imagegrpc.NewServer(app, cfg.GRPC, publicurl.NewBuilder(cfg.FileServer.PublicURL))
+	fileServer := fileserver.NewFileServer(cfg.FileServer, checker)
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return imageServer.RunServer()
+	})
+	g.Go(func() error {
+		return fileServer.Run()
+	})
+	consumerDone := make(chan struct{})
+	g.Go(func() error {
+		defer close(consumerDone)
+		// после отмены gctx консьюмер перестает брать сообщения и ждет уже начатые обработки
+		err := consumer.Run(gctx, cfg.AMT.Workers, cfg.AMT.Timeout)
+		if gctx.Err() != nil {
+			return nil // остановка по сигналу или из-за ошибки другого компонента
+		}
+		return err
+	})
+	g.Go(func() error {
+		watchHealth(gctx, checker, imageServer, cfg.Health.Interval)
+		return nil
+	})
+	g.Go(func() error {
+		<-gctx.Done()
+		slog.Info("shutting down image service", "timeout", cfg.Shutdown.Timeout)
+		checker.Drain()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+		defer cancel()
+		return shutdown(shutdownCtx, imageServer, fileServer, amtHandler, consumerDone, app)
+	})
+
+	slog.Info("image service started", "grpc", cfg.GRPC.Addr, "fileserver", cfg.FileServer.Port)
+	return g.Wait()
+}
+
+// storageBackend - хранилище файлов вместе с проверкой готовности
+type storageBackend interface {
+	application.StorageAPI
+	Check(ctx context.Context) error
+}
+
+// blobBackend - хранилище, поверх которого может работать режим cas
+type blobBackend interface {
+	storageBackend
+	storage.BlobStore
+}
+
+// newStorage выбирает хранилище по storage.backend, а при storage.layout: cas
+// файлы сохраняются по содержимому со счетчиками ссылок в БД
+func newStorage(ctx context.Context, cfg config.Storage, refs storage.RefStore) (storageBackend, error) {
+	var (
+		backend blobBackend
+		err     error
+	)
+	if cfg.Backend == "s3" {
+		backend, err = storage.NewS3Storage(ctx, cfg)
+	} else {
+		backend, err = newLocalStorage(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Layout == "cas" {
+		return storage.NewCASStorage(backend, refs, cfg.JPEGQuality), nil
+	}
+	return backend, nil
+}
+
+// newLocalStorage открывает том и удаляет файлы, недописанные до падения прошлого запуска.
+// Ошибка уборки не мешает старту - такие файлы никуда не ссылаются
+func newLocalStorage(cfg config.Storage) (storage.Storage, error) {
+	st, err := storage.NewStorage(cfg)
+	if err != nil {
+		return storage.Storage{}, err
+	}
+	removed, err := st.SweepPartial()
+	if err != nil {
+		slog.Warn("failed to remove partial files", "path", cfg.Path, "removed", removed, "error", err)
+	} else if removed > 0 {
+		slog.Info("removed partial files", "path", cfg.Path, "removed", removed)
+	}
+	return st, nil
+}
+
+// watchHealth переносит результат проверок готовности в статус grpc.health.v1
+func watchHealth(ctx context.Context, checker *health.Checker, imageServer *imagegrpc.ImageServer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ready, results := checker.Ready(ctx)
+		if ctx.Err() != nil {
+			return // при остановке статус уже выставлен в Shutdown
+		}
+		imageServer.SetServing(ready)
+		if !ready {
+			slog.Warn("image service is not ready", "checks", results)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdown останавливает подсистемы по порядку в пределах дедлайна ctx:
+// сначала входящие запросы и очередь, затем фоновая работа приложения,
+// а пул БД закрывается уже после возврата из run
+func shutdown(ctx context.Context, imageServer *imagegrpc.ImageServer, fileServer *fileserver.FileServer,
+	amtHandler *imageamt.AMTHandler, consumerDone <-chan struct{}, app *application.App) error {
+	// новые стримы не принимаются, текущие UploadImage дорабатывают
+	imageServer.Shutdown(ctx)
+
+	// текущие ProcessedSave дорабатывают, а по дедлайну прерываются и уходят на повтор
+	select {
+	case <-consumerDone:
+	case <-ctx.Done():
+		amtHandler.Abort()
+		<-consumerDone
+	}
+
+	// оставшиеся горутины приложения и busy статусы
+	errApp := app.Shutdown(ctx)
+	errFS := fileServer.Shutdown(ctx)
+	return errors.Join(errApp, errFS)
+}
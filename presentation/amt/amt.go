@@ -11,15 +11,18 @@ import (
 )
 
 type AppAPI interface {
-	ProcessedSave(ctx context.Context, service, entityID, imageID, tmpImagePath string, isCover bool) error
+	ProcessedSave(ctx context.Context, msg models.ProcessImageMessage) error
 }
 
 type AMTHandler struct {
-	App AppAPI
+	App   AppAPI
+	abort context.Context // отменяется, когда на остановку больше нет времени
+	stop  context.CancelFunc
 }
 
 func NewAMTHandler(app AppAPI) *AMTHandler {
-	return &AMTHandler{App: app}
+	abort, stop := context.WithCancel(context.Background())
+	return &AMTHandler{App: app, abort: abort, stop: stop}
 }
 
 func (a *AMTHandler) ProcessMessage(ctx context.Context, msg amqp091.Delivery) error {
@@ -28,9 +31,31 @@ func (a *AMTHandler) ProcessMessage(ctx context.Context, msg amqp091.Delivery) e
 	if err != nil {
 		return amt.NewErrNack("Invalid input")
 	}
-	err = a.App.ProcessedSave(ctx, imgmsg.Service, imgmsg.EntityID, imgmsg.ImageID, imgmsg.TmpImagePath, imgmsg.IsCover)
-	if errors.Is(err, ctx.Err()) {
+
+	// остановка консьюмера отменяет ctx всех обработчиков, а начатая обработка должна доработать -
+	// поэтому от ctx берется только дедлайн, а оборвать работу можно только через Abort
+	var (
+		jobCtx context.Context
+		cancel context.CancelFunc
+	)
+	if deadline, ok := ctx.Deadline(); ok {
+		jobCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	} else {
+		jobCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	defer cancel()
+	stopAbort := context.AfterFunc(a.abort, cancel)
+	defer stopAbort()
+
+	err = a.App.ProcessedSave(jobCtx, *imgmsg)
+	// прерванная обработка возвращается в очередь, временное изображение при этом остается на месте
+	if errors.Is(err, jobCtx.Err()) || errors.Is(err, models.ErrShuttingDown) {
 		return err
 	}
 	return amt.NewErrNack("Unprocessable entity")
 }
+
+// Abort обрывает текущие обработки - их сообщения уходят на повтор
+func (a *AMTHandler) Abort() {
+	a.stop()
+}
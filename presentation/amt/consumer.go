@@ -0,0 +1,44 @@
+package amt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	amt "github.com/glekoz/online-shop_amt"
+)
+
+var ErrConsumerDown = errors.New("amt consumer is not running")
+
+// Consumer запускает Flow и помнит, жив ли он - соединение с брокером
+// спрятано внутри amt, поэтому живость консьюмера и есть живость соединения
+type Consumer struct {
+	flow  *amt.Flow
+	alive atomic.Bool
+}
+
+func NewConsumer(flow *amt.Flow) *Consumer {
+	return &Consumer{flow: flow}
+}
+
+func (c *Consumer) Run(ctx context.Context, workers int, timeout time.Duration) (err error) {
+	c.alive.Store(true)
+	defer func() {
+		c.alive.Store(false)
+		// при закрытии канала сообщений (потеря соединения) RunConsumer паникует
+		if r := recover(); r != nil {
+			err = fmt.Errorf("amt consumer stopped: %v", r)
+		}
+	}()
+	return c.flow.RunConsumer(ctx, workers, timeout)
+}
+
+// Check используется в проверке готовности
+func (c *Consumer) Check(ctx context.Context) error {
+	if !c.alive.Load() {
+		return ErrConsumerDown
+	}
+	return nil
+}
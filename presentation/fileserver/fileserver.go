@@ -1,31 +1,80 @@
 package fileserver
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"time"
+
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/health"
 )
 
 type FileServer struct {
-	port int
-	path string //some/path/static
+	port   int
+	path   string //some/path/static
+	health *health.Checker
+	srv    *http.Server
 }
 
-func (s *FileServer) Run() error {
-	srv := &http.Server{
+func NewFileServer(cfg config.FileServer, checker *health.Checker) *FileServer {
+	s := &FileServer{port: cfg.Port, path: cfg.Path, health: checker}
+	s.srv = &http.Server{
 		Addr:         fmt.Sprintf(":%v", s.port),
 		Handler:      s.Routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  cfg.IdleTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}
+	return s
+}
+
+func (s *FileServer) Run() error {
+	err := s.srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
-	err := srv.ListenAndServe()
 	return err
 }
 
+func (s *FileServer) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
 func (s *FileServer) Routes() *http.ServeMux {
 	mux := http.NewServeMux()
 	fs := http.FileServer(http.Dir(s.path))
 	mux.Handle("GET /static/", http.StripPrefix("/static", fs))
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
 	return mux
 }
+
+// healthz - процесс жив и обслуживает HTTP
+func (s *FileServer) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+// readyz - зависимости доступны и сервис не останавливается
+func (s *FileServer) readyz(w http.ResponseWriter, r *http.Request) {
+	ready, results := s.health.Ready(r.Context())
+	resp := struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}{Status: "ok", Checks: make(map[string]string, len(results))}
+	for name, err := range results {
+		if err != nil {
+			resp.Checks[name] = err.Error()
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		resp.Status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,107 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// В protoimage нет запроса по цветам, поэтому сервис описан вручную, а сообщения -
+// google.protobuf.Struct, который клиент собирает без своих .proto:
+//
+//	/image.ColorSearch/FindEntitiesByColor
+//	запрос: {"service": "product", "color": "blue", "min_share": 0.2, "after": "", "limit": 100}
+//	ответ:  {"entity_ids": ["..."], "next_after": "..."}
+//
+// next_after - последний id страницы, пустой, если страница неполная
+const colorSearchServiceName = "image.ColorSearch"
+
+// сколько id вернуть, если limit не задан
+const defaultColorSearchLimit = 100
+
+type ColorSearchServer interface {
+	FindEntitiesByColor(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error)
+}
+
+var colorSearchServiceDesc = grpc.ServiceDesc{
+	ServiceName: colorSearchServiceName,
+	HandlerType: (*ColorSearchServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "FindEntitiesByColor",
+			Handler:    findEntitiesByColorHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "colorsearch",
+}
+
+// так же, как это делает protoc-gen-go-grpc
+func findEntitiesByColorHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(structpb.Struct)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ColorSearchServer).FindEntitiesByColor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/" + colorSearchServiceName + "/FindEntitiesByColor",
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(ColorSearchServer).FindEntitiesByColor(ctx, req.(*structpb.Struct))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func (s *ImageServer) FindEntitiesByColor(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	fields := req.GetFields()
+	reqData := models.FindByColorRequest{
+		Service:  fields["service"].GetStringValue(),
+		Color:    fields["color"].GetStringValue(),
+		MinShare: fields["min_share"].GetNumberValue(),
+		After:    fields["after"].GetStringValue(),
+		Limit:    int(fields["limit"].GetNumberValue()),
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err := validate.Struct(reqData)
+	if err != nil {
+		errs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return nil, status.Error(codes.Internal, "error validation")
+		}
+		names := make([]string, 0, len(errs))
+		for _, err := range errs {
+			names = append(names, err.StructField())
+		}
+		return nil, status.Error(codes.InvalidArgument, strings.Join(names, " "))
+	}
+	if reqData.Limit == 0 {
+		reqData.Limit = defaultColorSearchLimit
+	}
+
+	ids, err := s.App.FindEntitiesByColor(ctx, reqData.Service, reqData.Color, reqData.MinShare, reqData.After, reqData.Limit)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "no way to find entities")
+	}
+	list := make([]any, len(ids))
+	for i, id := range ids {
+		list[i] = id
+	}
+	next := ""
+	if len(ids) == reqData.Limit {
+		next = ids[len(ids)-1]
+	}
+	resp, err := structpb.NewStruct(map[string]any{"entity_ids": list, "next_after": next})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
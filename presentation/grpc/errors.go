@@ -0,0 +1,85 @@
+package grpc
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/imageproc"
+	"github.com/glekoz/online-shop_image/internal/models"
+	"github.com/glekoz/online-shop_image/internal/publicurl"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// decodeStatus переводит ошибку декодирования загрузки в InvalidArgument.
+// Неразрешенный формат и нарушенные ограничения по размерам уходят клиенту
+// в errdetails.BadRequest, по одному FieldViolation на каждое
+func decodeStatus(err error) error {
+	var (
+		formatErr *imageproc.FormatError
+		limitErr  *imageproc.LimitError
+		st        *status.Status
+		br        = &errdetails.BadRequest{}
+	)
+	switch {
+	case errors.As(err, &formatErr):
+		st = status.New(codes.InvalidArgument, formatErr.Error())
+		br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       "image.format",
+			Description: formatErr.Error(),
+		})
+	case errors.As(err, &limitErr):
+		st = status.New(codes.InvalidArgument, limitErr.Error())
+		for _, v := range limitErr.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       "image." + v.Field,
+				Description: v.Description,
+			})
+		}
+	default:
+		return status.Error(codes.InvalidArgument, "decoding failed")
+	}
+	if detailed, derr := st.WithDetails(br); derr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// uploadErrText - текст ошибки изображения в UploadImageResponse.Err.
+// Отклоненный дубликат - короткое "duplicate of <ссылка или id>", низкое качество -
+// "low quality: <причины>", остальное как раньше
+func uploadErrText(err error, urls publicurl.Builder) string {
+	var (
+		dupErr     *models.DuplicateError
+		qualityErr *models.LowQualityError
+	)
+	switch {
+	case errors.As(err, &dupErr):
+		return "duplicate of " + duplicateRef(dupErr.Of, urls)
+	case errors.As(err, &qualityErr):
+		return qualityErr.Error()
+	}
+	return err.Error()
+}
+
+// uploadWarning - предупреждения для сохраненного изображения через "; ", "" - предупреждений нет
+func uploadWarning(upload models.UploadResult, urls publicurl.Builder) string {
+	var warnings []string
+	if upload.DuplicateOf != "" {
+		warnings = append(warnings, "warning: possible duplicate of "+duplicateRef(upload.DuplicateOf, urls))
+	}
+	if len(upload.QualityIssues) > 0 {
+		warnings = append(warnings, "warning: low quality: "+strings.Join(upload.QualityIssues, ", "))
+	}
+	return strings.Join(warnings, "; ")
+}
+
+// duplicateRef - как клиенту назвать похожее изображение: сохраненное - ссылкой вместо ключа
+// хранилища, принятое в этом же стриме - его id. Id - uuid без "/", а ключ всегда с папками
+func duplicateRef(of string, urls publicurl.Builder) string {
+	if !strings.Contains(of, "/") {
+		return of
+	}
+	return urls.URL(of)
+}
@@ -4,12 +4,11 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"image"
 	"io"
-	"net/http"
 	"strings"
 	"sync"
 
+	"github.com/glekoz/online-shop_image/internal/imageproc"
 	"github.com/glekoz/online-shop_image/internal/models"
 	protoimage "github.com/glekoz/online-shop_proto/protoimage"
 	"github.com/go-playground/validator/v10"
@@ -21,13 +20,15 @@ import (
 type AppAPI interface {
 	CreateEntity(ctx context.Context, service, entityID string, maxCount int) error
 	DeleteEntity(ctx context.Context, service, entityID string) error
-	InitialSave(ctx context.Context, service, entityID string, isCover bool, img image.Image) (string, error)
-	DeleteImage(ctx context.Context, imagePath string) error
+	DecodeImage(ctx context.Context, service string, data []byte) (imageproc.Decoded, error)
+	InitialSave(ctx context.Context, service, entityID string, isCover bool, data []byte, decoded imageproc.Decoded, focus *models.FocalPoint) (models.UploadResult, error)
+	DeleteImage(ctx context.Context, service, entityID, imagePath string) error
 	IsStatusFree(ctx context.Context, service, entityID string) (bool, error)
 	SetBusyStatus(ctx context.Context, service, entityID string) (bool, error)
 	SetFreeStatus(ctx context.Context, service, entityID string) (bool, error)
-	GetCoverImage(ctx context.Context, service, entityID string) (string, error)
-	GetImageList(ctx context.Context, service, entityID string) ([]string, error)
+	GetCoverImage(ctx context.Context, service, entityID string) (models.EntityImage, error)
+	GetImageList(ctx context.Context, service, entityID string) ([]models.EntityImage, error)
+	FindEntitiesByColor(ctx context.Context, service, name string, minShare float64, after string, limit int) ([]string, error)
 }
 
 func (s *ImageServer) CreateEntity(ctx context.Context, req *protoimage.CreateEntityRequest) (*protoimage.BoolResponse, error) {
@@ -162,6 +163,10 @@ func (s *ImageServer) UploadImage(stream grpc.BidiStreamingServer[protoimage.Upl
 		}
 		return status.Error(codes.InvalidArgument, strings.Join(fields, " "))
 	}
+	focalPoints, err := streamFocalPoints(stream.Context())
+	if err != nil {
+		return err
+	}
 
 	ok, err := s.App.SetBusyStatus(stream.Context(), cm.Service, cm.EntityID)
 	if err != nil {
@@ -171,13 +176,26 @@ func (s *ImageServer) UploadImage(stream grpc.BidiStreamingServer[protoimage.Upl
 		return status.Error(codes.Unavailable, "system is busy") // или codes.FailedPrecondition
 	}
 	defer func() {
-		_, err := s.App.SetFreeStatus(stream.Context(), cm.Service, cm.EntityID)
+		// статус снимается, даже если клиент оборвал стрим или сервер останавливается
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(stream.Context()), releaseTimeout)
+		defer cancel()
+		_, err := s.App.SetFreeStatus(ctx, cm.Service, cm.EntityID)
 		if err != nil {
 			// залогировать?
 		}
 	}()
 
-	var wg sync.WaitGroup
+	var (
+		wg     sync.WaitGroup
+		sendMu sync.Mutex // stream.Send нельзя вызывать из нескольких горутин одновременно
+		index  int        // номер изображения в стриме
+	)
+	send := func(resp *protoimage.UploadImageResponse) {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		stream.Send(resp)
+	}
+	defer wg.Wait() // при досрочном выходе тоже дожидаемся запущенных сохранений
 	for {
 		msg, err := stream.Recv()
 		if err != nil {
@@ -195,7 +213,7 @@ func (s *ImageServer) UploadImage(stream grpc.BidiStreamingServer[protoimage.Upl
 			if err != nil {
 				return status.Error(codes.InvalidArgument, "invalid image chunk")
 			}
-			if img.Len() > maxSize {
+			if img.Len() > s.cfg.MaxImageSize {
 				return status.Error(codes.InvalidArgument, "image is too big")
 			}
 		case msg.GetIsCover() != nil:
@@ -203,34 +221,36 @@ func (s *ImageServer) UploadImage(stream grpc.BidiStreamingServer[protoimage.Upl
 				return status.Error(codes.InvalidArgument, "cover flag should be sent after image")
 			}
 			imageBytes := img.Bytes()
-			imageType := http.DetectContentType(imageBytes)
-			if imageType != "image/jpeg" && imageType != "image/png" {
-				return status.Error(codes.InvalidArgument, "unsupported format")
-			}
-
-			reader := bytes.NewReader(imageBytes)
-			i, _, err := image.Decode(reader)
+			// формат определяется по сигнатуре, размеры - по заголовку до декодирования,
+			// изображение сразу разворачивается по EXIF, сами метаданные дальше не идут
+			decoded, err := s.App.DecodeImage(stream.Context(), cm.Service, imageBytes)
 			if err != nil {
-				return status.Error(codes.InvalidArgument, "decoding failed")
+				return decodeStatus(err)
 			}
 
 			isCover := msg.GetIsCover().GetValue()
+			var focus *models.FocalPoint
+			if index < len(focalPoints) {
+				focus = focalPoints[index]
+			}
+			index++
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				imageID, err := s.App.InitialSave(stream.Context(), cm.Service, cm.EntityID, isCover, i)
+				upload, err := s.App.InitialSave(stream.Context(), cm.Service, cm.EntityID, isCover, imageBytes, decoded, focus)
 				if err != nil {
 					// обработка ошибок - при критических сразу отменять контекст и возвращать ошибку по всему стриму
-					stream.Send(&protoimage.UploadImageResponse{ImageId: "", Err: err.Error()})
+					send(&protoimage.UploadImageResponse{ImageId: "", Err: uploadErrText(err, s.urls)})
+					return
 				}
-				stream.Send(&protoimage.UploadImageResponse{ImageId: imageID, Err: ""})
+				// при политиках flag и warn изображение сохранено, а в Err - предупреждение
+				send(&protoimage.UploadImageResponse{ImageId: upload.ImageID, Err: uploadWarning(upload, s.urls)})
 			}()
 			img = bytes.Buffer{}
 		default:
 			return status.Error(codes.InvalidArgument, "unexpected arguments")
 		}
 	}
-	wg.Wait()
 	return nil
 }
 
@@ -262,6 +282,8 @@ func (s *ImageServer) DeleteImage(ctx context.Context, req *protoimage.DeleteIma
 		return &protoimage.DeleteImageResponse{Resp: nil}, status.Error(codes.Unavailable, "system is busy")
 	}
 	defer func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+		defer cancel()
 		_, err := s.App.SetFreeStatus(ctx, reqData.Service, reqData.EntityID)
 		if err != nil {
 			// залогировать?
@@ -273,7 +295,12 @@ func (s *ImageServer) DeleteImage(ctx context.Context, req *protoimage.DeleteIma
 		Error error
 	}
 	for _, image := range reqData.Images {
-		if err = s.App.DeleteImage(ctx, image); err != nil { // можно переделать, чтобы метод принимал слайс или вариадик
+		// клиент присылает ссылку из GetImageList или GetCoverImage
+		key, err := s.urls.Key(image)
+		if err == nil {
+			err = s.App.DeleteImage(ctx, reqData.Service, reqData.EntityID, key)
+		}
+		if err != nil { // можно переделать, чтобы метод принимал слайс или вариадик
 			errs = append(errs, struct {
 				Image string
 				Error error
@@ -308,7 +335,7 @@ func (s *ImageServer) GetCoverImage(ctx context.Context, req *protoimage.CommonM
 		}
 		return &protoimage.GetCoverImageResponse{CoverImagePath: ""}, status.Error(codes.InvalidArgument, strings.Join(fields, " "))
 	}
-	path, err := s.App.GetCoverImage(ctx, cm.Service, cm.EntityID)
+	cover, err := s.App.GetCoverImage(ctx, cm.Service, cm.EntityID)
 	if err != nil {
 		// обработка различных ошибок, а не только этой
 		switch {
@@ -318,7 +345,8 @@ func (s *ImageServer) GetCoverImage(ctx context.Context, req *protoimage.CommonM
 			return &protoimage.GetCoverImageResponse{CoverImagePath: ""}, status.Error(codes.Internal, "no way to get cover")
 		}
 	}
-	return &protoimage.GetCoverImageResponse{CoverImagePath: path}, nil
+	grpc.SetHeader(ctx, imagesMetadata([]models.EntityImage{cover}, s.urls))
+	return &protoimage.GetCoverImageResponse{CoverImagePath: s.urls.URL(cover.ImagePath)}, nil
 }
 
 func (s *ImageServer) GetImageList(ctx context.Context, req *protoimage.CommonMetadata) (*protoimage.GetImageListResponse, error) {
@@ -348,5 +376,10 @@ func (s *ImageServer) GetImageList(ctx context.Context, req *protoimage.CommonMe
 			return &protoimage.GetImageListResponse{ImagePath: nil}, status.Error(codes.Internal, "no way to get images")
 		}
 	}
-	return &protoimage.GetImageListResponse{ImagePath: images}, nil
+	paths := make([]string, 0, len(images))
+	for _, image := range images {
+		paths = append(paths, s.urls.URL(image.ImagePath))
+	}
+	grpc.SetHeader(ctx, imagesMetadata(images, s.urls))
+	return &protoimage.GetImageListResponse{ImagePath: paths}, nil
 }
@@ -0,0 +1,88 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/glekoz/online-shop_image/internal/models"
+	"github.com/glekoz/online-shop_image/internal/publicurl"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// в protoimage нет полей для вариантов, поэтому ссылки на них уходят в заголовках ответа:
+// ключ variant-<имя>, значения идут в том же порядке, что и основные ссылки в ответе,
+// пустая строка - у изображения нет такого варианта
+const variantKeyPrefix = "variant-"
+
+// mime тип основных изображений, заглушка для фронтенда (BlurHash и цвет "#rrggbb") и размеры
+// исходника "WxH" до обрезки и полей, в том же порядке, у изображений без них - пустые строки
+const (
+	mimeTypeKey      = "mime-type"
+	blurHashKey      = "blurhash"
+	dominantColorKey = "dominant-color"
+	originalSizeKey  = "original-size"
+)
+
+func imagesMetadata(images []models.EntityImage, urls publicurl.Builder) metadata.MD {
+	md := metadata.MD{
+		mimeTypeKey:      make([]string, len(images)),
+		blurHashKey:      make([]string, len(images)),
+		dominantColorKey: make([]string, len(images)),
+		originalSizeKey:  make([]string, len(images)),
+	}
+	for i, image := range images {
+		md[mimeTypeKey][i] = image.MimeType
+		md[blurHashKey][i] = image.BlurHash
+		md[dominantColorKey][i] = image.DominantColor
+		if image.OriginalWidth > 0 && image.OriginalHeight > 0 {
+			md[originalSizeKey][i] = fmt.Sprintf("%dx%d", image.OriginalWidth, image.OriginalHeight)
+		}
+		for _, v := range image.Variants {
+			key := variantKeyPrefix + v.Name
+			if _, ok := md[key]; !ok {
+				md[key] = make([]string, len(images))
+			}
+			md[key][i] = urls.URL(v.Path)
+		}
+	}
+	return md
+}
+
+// точки интереса для обрезки приходят в заголовках UploadImage: по значению "x,y" на изображение
+// в порядке их отправки, x и y - доли ширины и высоты развернутого изображения от 0 до 1,
+// пустое значение или отсутствие значения - точки нет
+const focalPointKey = "focal-point"
+
+func streamFocalPoints(ctx context.Context) ([]*models.FocalPoint, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(focalPointKey)
+	points := make([]*models.FocalPoint, len(values))
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+		p, err := parseFocalPoint(v)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("%s %d: %v", focalPointKey, i, err))
+		}
+		points[i] = p
+	}
+	return points, nil
+}
+
+func parseFocalPoint(s string) (*models.FocalPoint, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	if !ok {
+		return nil, fmt.Errorf("%q must look like 0.5,0.3", s)
+	}
+	x, err1 := strconv.ParseFloat(strings.TrimSpace(xs), 64)
+	y, err2 := strconv.ParseFloat(strings.TrimSpace(ys), 64)
+	if err1 != nil || err2 != nil || !(x >= 0 && x <= 1 && y >= 0 && y <= 1) {
+		return nil, fmt.Errorf("%q must be two fractions from 0 to 1", s)
+	}
+	return &models.FocalPoint{X: x, Y: y}, nil
+}
@@ -1,32 +1,73 @@
 package grpc
 
 import (
+	"context"
 	"net"
+	"time"
 
+	"github.com/glekoz/online-shop_image/internal/config"
+	"github.com/glekoz/online-shop_image/internal/publicurl"
 	"github.com/glekoz/online-shop_proto/protoimage"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-const (
-	maxSize        = 5 << 20
-	maxMessageSize = 1 << 20
-)
+// время на снятие busy статуса, когда контекст запроса уже отменен
+const releaseTimeout = 5 * time.Second
 
 type ImageServer struct {
-	App AppAPI
+	App    AppAPI
+	cfg    config.GRPC
+	urls   publicurl.Builder // в БД ключи хранилища, клиентам уходят ссылки
+	server *grpc.Server
+	health *health.Server
 	protoimage.UnimplementedImageServer
 }
 
-func NewServer(app AppAPI) *ImageServer {
-	return &ImageServer{App: app}
+func NewServer(app AppAPI, cfg config.GRPC, urls publicurl.Builder) *ImageServer {
+	IS := &ImageServer{App: app, cfg: cfg, urls: urls}
+	IS.server = grpc.NewServer(grpc.MaxRecvMsgSize(cfg.MaxMessageSize))
+	protoimage.RegisterImageServer(IS.server, IS)
+	IS.server.RegisterService(&colorSearchServiceDesc, IS)
+	IS.health = health.NewServer()
+	healthpb.RegisterHealthServer(IS.server, IS.health)
+	IS.SetServing(false) // до первой успешной проверки готовности
+	return IS
+}
+
+// SetServing выставляет статус grpc.health.v1 для всего сервера и для сервисов Image и ColorSearch
+func (IS *ImageServer) SetServing(ok bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if ok {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+	IS.health.SetServingStatus("", status)
+	IS.health.SetServingStatus(protoimage.Image_ServiceDesc.ServiceName, status)
+	IS.health.SetServingStatus(colorSearchServiceName, status)
 }
 
-func (IS *ImageServer) RunServer(app AppAPI) error { // все общие компоненты должны настраиваться в мейне
-	listen, err := net.Listen("tcp", ":8080")
+func (IS *ImageServer) RunServer() error { // все общие компоненты должны настраиваться в мейне
+	listen, err := net.Listen("tcp", IS.cfg.Addr)
 	if err != nil {
 		return err
 	}
-	grpcServer := grpc.NewServer(grpc.MaxRecvMsgSize(maxMessageSize))
-	protoimage.RegisterImageServer(grpcServer, IS)
-	return grpcServer.Serve(listen)
+	return IS.server.Serve(listen)
+}
+
+// Shutdown перестает принимать новые соединения и стримы и ждет завершения текущих вызовов,
+// а по истечении ctx обрывает оставшиеся
+func (IS *ImageServer) Shutdown(ctx context.Context) {
+	IS.health.Shutdown() // NOT_SERVING и запрет на дальнейшие изменения статуса
+	done := make(chan struct{})
+	go func() {
+		IS.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		IS.server.Stop()
+		<-done
+	}
 }